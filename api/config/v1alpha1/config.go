@@ -0,0 +1,199 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// NewDefault returns the configuration of an operator started without a file nor flags
+func NewDefault() *OperatorConfig {
+	return &OperatorConfig{
+		TypeMeta: metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: Kind},
+		Metrics:  MetricsConfig{BindAddress: ":8080"},
+		Health:   HealthConfig{BindAddress: ":8081"},
+		LeaderElection: LeaderElectionConfig{
+			Enabled:       true,
+			LeaseDuration: metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline: metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:   metav1.Duration{Duration: 2 * time.Second},
+		},
+		Controller: ControllerConfig{
+			MaxConcurrentReconciles: 1,
+			ResyncPeriod:            metav1.Duration{Duration: 10 * time.Minute},
+			// the defaults of controller-runtime
+			RequeueBackoff: RequeueBackoff{
+				BaseDelay: metav1.Duration{Duration: 5 * time.Millisecond},
+				MaxDelay:  metav1.Duration{Duration: 1000 * time.Second},
+				QPS:       10,
+				Burst:     100,
+			},
+			Debounce: Debounce{MaxDelay: metav1.Duration{Duration: 10 * time.Second}},
+		},
+		SyncPeriod: metav1.Duration{Duration: 10 * time.Hour},
+	}
+}
+
+// AddFlags binds the flags overriding the fields of the configuration
+func (in *OperatorConfig) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&in.Metrics.BindAddress, "metrics-bind-address", in.Metrics.BindAddress, "Address serving the metrics; 0 disables them")
+	fs.StringVar(&in.Health.BindAddress, "health-probe-bind-address", in.Health.BindAddress, "Address serving the /healthz and /readyz probes; 0 disables them")
+	fs.BoolVar(&in.LeaderElection.Enabled, "leader-elect", in.LeaderElection.Enabled, "Elect the instance reconciling the merges when several run")
+	fs.StringVar(&in.LeaderElection.ID, "leader-election-id", in.LeaderElection.ID, "Name of the lease of the leader election; the default one of the operator when empty")
+	fs.DurationVar(&in.LeaderElection.LeaseDuration.Duration, "leader-elect-lease-duration", in.LeaderElection.LeaseDuration.Duration, "How long the other instances wait before taking over the lease")
+	fs.DurationVar(&in.LeaderElection.RenewDeadline.Duration, "leader-elect-renew-deadline", in.LeaderElection.RenewDeadline.Duration, "How long the leader retries renewing the lease before giving it up")
+	fs.DurationVar(&in.LeaderElection.RetryPeriod.Duration, "leader-elect-retry-period", in.LeaderElection.RetryPeriod.Duration, "How long the instances wait between two attempts to acquire or renew the lease")
+	fs.IntVar(&in.Controller.MaxConcurrentReconciles, "max-concurrent-reconciles", in.Controller.MaxConcurrentReconciles, "Number of merges reconciled in parallel")
+	fs.DurationVar(&in.Controller.ResyncPeriod.Duration, "resync-period", in.Controller.ResyncPeriod.Duration, "How often the patches are compared with their targets to repair manual edits; 0 disables it")
+	fs.DurationVar(&in.Controller.RequeueBackoff.BaseDelay.Duration, "requeue-base-delay", in.Controller.RequeueBackoff.BaseDelay.Duration, "Delay before the first retry of a failed reconcile, doubled at each failure")
+	fs.DurationVar(&in.Controller.RequeueBackoff.MaxDelay.Duration, "requeue-max-delay", in.Controller.RequeueBackoff.MaxDelay.Duration, "Longest delay before retrying a failed reconcile")
+	fs.Float64Var(&in.Controller.RequeueBackoff.QPS, "requeue-qps", in.Controller.RequeueBackoff.QPS, "Retries of the failed reconciles allowed per second overall")
+	fs.IntVar(&in.Controller.RequeueBackoff.Burst, "requeue-burst", in.Controller.RequeueBackoff.Burst, "Retries of the failed reconciles allowed in a burst overall")
+	fs.DurationVar(&in.Controller.Debounce.Window.Duration, "debounce-window", in.Controller.Debounce.Window.Duration, "How long the changes of the merges of a target are held back to be written at once; 0 disables it")
+	fs.DurationVar(&in.Controller.Debounce.MaxDelay.Duration, "debounce-max-delay", in.Controller.Debounce.MaxDelay.Duration, "Longest time the first held back change of the merges of a target waits")
+	fs.DurationVar(&in.SyncPeriod.Duration, "sync-period", in.SyncPeriod.Duration, "How often the cache lists the watched resources again")
+	fs.Var(featureGatesFlag{in}, "feature-gates", "Comma separated feature=true|false pairs enabling or disabling optional merge behaviors")
+}
+
+// Load reads the configuration file over the configuration
+func (in *OperatorConfig) Load(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(content, in); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if in.APIVersion != GroupVersion.String() || in.Kind != Kind {
+		return fmt.Errorf("%s: expected a %s of %s, got a %s of %s", path, Kind, GroupVersion, in.Kind, in.APIVersion)
+	}
+	return nil
+}
+
+// Validate checks the configuration, except the names of the feature gates
+func (in *OperatorConfig) Validate() error {
+	var errs []error
+	if in.Metrics.BindAddress == "" {
+		errs = append(errs, fmt.Errorf("metrics.bindAddress is empty"))
+	}
+	if in.Health.BindAddress == "" {
+		errs = append(errs, fmt.Errorf("health.bindAddress is empty"))
+	}
+	if election := in.LeaderElection; election.Enabled {
+		if election.RetryPeriod.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("leaderElection.retryPeriod must be positive"))
+		}
+		if election.RenewDeadline.Duration <= time.Duration(leaderelection.JitterFactor*float64(election.RetryPeriod.Duration)) {
+			errs = append(errs, fmt.Errorf("leaderElection.renewDeadline must be greater than %v times the retryPeriod", leaderelection.JitterFactor))
+		}
+		if election.LeaseDuration.Duration <= election.RenewDeadline.Duration {
+			errs = append(errs, fmt.Errorf("leaderElection.leaseDuration must be greater than the renewDeadline"))
+		}
+	}
+	if in.Controller.MaxConcurrentReconciles < 1 {
+		errs = append(errs, fmt.Errorf("controller.maxConcurrentReconciles must be at least 1"))
+	}
+	if in.Controller.ResyncPeriod.Duration < 0 {
+		errs = append(errs, fmt.Errorf("controller.resyncPeriod must not be negative"))
+	}
+	if backoff := in.Controller.RequeueBackoff; backoff.BaseDelay.Duration <= 0 || backoff.MaxDelay.Duration < backoff.BaseDelay.Duration {
+		errs = append(errs, fmt.Errorf("controller.requeueBackoff.baseDelay must be positive and at most the maxDelay"))
+	}
+	if backoff := in.Controller.RequeueBackoff; backoff.QPS <= 0 || backoff.Burst < 1 {
+		errs = append(errs, fmt.Errorf("controller.requeueBackoff.qps and burst must be positive"))
+	}
+	if debounce := in.Controller.Debounce; debounce.Window.Duration < 0 ||
+		(debounce.Window.Duration > 0 && debounce.MaxDelay.Duration < debounce.Window.Duration) {
+		errs = append(errs, fmt.Errorf("controller.debounce.window must not be negative nor longer than the maxDelay"))
+	}
+	if in.SyncPeriod.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("syncPeriod must be positive"))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ApplyTo sets the options of the manager configured
+func (in *OperatorConfig) ApplyTo(options *ctrl.Options) {
+	options.MetricsBindAddress = in.Metrics.BindAddress
+	options.HealthProbeBindAddress = in.Health.BindAddress
+	options.LeaderElection = in.LeaderElection.Enabled
+	if in.LeaderElection.ID != "" {
+		options.LeaderElectionID = in.LeaderElection.ID
+	}
+	options.LeaseDuration = &in.LeaderElection.LeaseDuration.Duration
+	options.RenewDeadline = &in.LeaderElection.RenewDeadline.Duration
+	options.RetryPeriod = &in.LeaderElection.RetryPeriod.Duration
+	options.SyncPeriod = &in.SyncPeriod.Duration
+}
+
+// RateLimiter returns the rate limiter of the retries of the failed reconciles
+func (in *OperatorConfig) RateLimiter() workqueue.RateLimiter {
+	backoff := in.Controller.RequeueBackoff
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(backoff.BaseDelay.Duration, backoff.MaxDelay.Duration),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(backoff.QPS), backoff.Burst)},
+	)
+}
+
+// featureGatesFlag sets the feature gates of the configuration from feature=true|false pairs
+type featureGatesFlag struct {
+	config *OperatorConfig
+}
+
+func (f featureGatesFlag) String() string {
+	if f.config == nil {
+		return ""
+	}
+	var pairs []string
+	for feature, enabled := range f.config.FeatureGates {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", feature, enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f featureGatesFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		feature, enabled, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return fmt.Errorf("%q is not a feature=true|false pair", pair)
+		}
+		on, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return fmt.Errorf("%q is not a feature=true|false pair", pair)
+		}
+		if f.config.FeatureGates == nil {
+			f.config.FeatureGates = map[string]bool{}
+		}
+		f.config.FeatureGates[feature] = on
+	}
+	return nil
+}
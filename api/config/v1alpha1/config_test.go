@@ -0,0 +1,79 @@
+package v1alpha1_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/config/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OperatorConfig", func() {
+	write := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		return path
+	}
+
+	It("is valid by default", func() {
+		Expect(v1alpha1.NewDefault().Validate()).To(Succeed())
+	})
+
+	It("loads a file over the defaults, overridden by the flags", func() {
+		config := v1alpha1.NewDefault()
+		fs := flag.NewFlagSet("operator", flag.ContinueOnError)
+		config.AddFlags(fs)
+		args := []string{"-max-concurrent-reconciles=4", "-feature-gates=DriftCorrection=false"}
+		Expect(fs.Parse(args)).To(Succeed())
+		Expect(config.Load(write(`
+apiVersion: config.istiomerger.monime.sl/v1alpha1
+kind: OperatorConfig
+leaderElection:
+  enabled: false
+controller:
+  maxConcurrentReconciles: 2
+  requeueBackoff:
+    maxDelay: 5m
+featureGates:
+  TargetCreation: false
+`))).To(Succeed())
+		Expect(fs.Parse(args)).To(Succeed())
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.LeaderElection.Enabled).To(BeFalse())
+		Expect(config.Controller.MaxConcurrentReconciles).To(Equal(4))
+		Expect(config.Controller.RequeueBackoff.MaxDelay.Duration).To(Equal(5 * time.Minute))
+		Expect(config.Controller.ResyncPeriod.Duration).To(Equal(10 * time.Minute))
+		Expect(config.FeatureGates).To(Equal(map[string]bool{"TargetCreation": false, "DriftCorrection": false}))
+	})
+
+	It("rejects the files of another kind or with unknown fields", func() {
+		Expect(v1alpha1.NewDefault().Load(write("apiVersion: v1\nkind: ConfigMap\n"))).NotTo(Succeed())
+		Expect(v1alpha1.NewDefault().Load(write(`
+apiVersion: config.istiomerger.monime.sl/v1alpha1
+kind: OperatorConfig
+controller:
+  workers: 2
+`))).NotTo(Succeed())
+	})
+
+	It("rejects the invalid settings", func() {
+		config := v1alpha1.NewDefault()
+		config.Controller.MaxConcurrentReconciles = 0
+		config.LeaderElection.RenewDeadline.Duration = config.LeaderElection.LeaseDuration.Duration
+		config.Controller.RequeueBackoff.MaxDelay.Duration = time.Millisecond
+		err := config.Validate()
+		Expect(err).To(MatchError(ContainSubstring("maxConcurrentReconciles")))
+		Expect(err).To(MatchError(ContainSubstring("leaseDuration")))
+		Expect(err).To(MatchError(ContainSubstring("baseDelay")))
+	})
+
+	It("ignores the lease timings without leader election", func() {
+		config := v1alpha1.NewDefault()
+		config.LeaderElection.Enabled = false
+		config.LeaderElection.RetryPeriod.Duration = 0
+		Expect(config.Validate()).To(Succeed())
+	})
+})
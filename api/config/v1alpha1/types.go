@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package v1alpha1 contains the configuration file of the operator
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// GroupVersion is the group version of the configuration file
+	GroupVersion = schema.GroupVersion{Group: "config.istiomerger.monime.sl", Version: "v1alpha1"}
+)
+
+// Kind is the kind of the configuration file
+const Kind = "OperatorConfig"
+
+// OperatorConfig configures the operator. The flags set on the command line override it.
+type OperatorConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Metrics        MetricsConfig        `json:"metrics,omitempty"`
+	Health         HealthConfig         `json:"health,omitempty"`
+	LeaderElection LeaderElectionConfig `json:"leaderElection,omitempty"`
+	Controller     ControllerConfig     `json:"controller,omitempty"`
+	// SyncPeriod is how often the cache lists the watched resources again
+	SyncPeriod metav1.Duration `json:"syncPeriod,omitempty"`
+	// FeatureGates enables or disables the optional merge behaviors by name
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// MetricsConfig configures the metrics endpoint
+type MetricsConfig struct {
+	// BindAddress is the address serving the metrics; "0" disables them
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// HealthConfig configures the probes endpoint
+type HealthConfig struct {
+	// BindAddress is the address serving /healthz and /readyz; "0" disables them
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// LeaderElectionConfig configures the election of the instance reconciling the merges
+type LeaderElectionConfig struct {
+	Enabled bool `json:"enabled"`
+	// ID names the lease; empty means the default one of the operator
+	ID string `json:"id,omitempty"`
+	// LeaseDuration is how long the other instances wait before taking over the lease
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewDeadline is how long the leader retries renewing the lease before giving it up
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	// RetryPeriod is how long the instances wait between two attempts to acquire or renew the lease
+	RetryPeriod metav1.Duration `json:"retryPeriod,omitempty"`
+}
+
+// ControllerConfig configures the reconciles of the merges
+type ControllerConfig struct {
+	// MaxConcurrentReconciles is the number of merges reconciled in parallel
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+	// ResyncPeriod is how often an applied merge is compared with its target; 0 disables it
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// RequeueBackoff delays the retries of the failed reconciles
+	RequeueBackoff RequeueBackoff `json:"requeueBackoff,omitempty"`
+	// Debounce coalesces the changes of the merges of a target into one write
+	Debounce Debounce `json:"debounce,omitempty"`
+}
+
+// Debounce holds back the changes of the merges of a target until none arrived for Window,
+// or MaxDelay after the first one, and then writes all of them at once; a zero Window disables it
+type Debounce struct {
+	Window   metav1.Duration `json:"window,omitempty"`
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+}
+
+// RequeueBackoff delays the retries of a failed reconcile exponentially from BaseDelay up to MaxDelay,
+// and the retries of all the merges by a token bucket of QPS tokens per second holding up to Burst
+type RequeueBackoff struct {
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+	MaxDelay  metav1.Duration `json:"maxDelay,omitempty"`
+	QPS       float64         `json:"qps,omitempty"`
+	Burst     int             `json:"burst,omitempty"`
+}
@@ -16,7 +16,13 @@
 
 package v1alpha1
 
-import "errors"
+import (
+	"errors"
+
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 var (
 	errEmptyTargetName = errors.New("empty target name")
@@ -34,6 +40,43 @@ type Target struct {
 	Name string `json:"name,omitempty"`
 
 	Namespace string `json:"namespace,omitempty"`
+
+	// CreateIfMissing creates the target from this template when it does not exist. The target created
+	// is labelled as managed by the operator and deleted once no VirtualServiceMerge references it.
+	// It is ignored in the target of a MergePolicy.
+	CreateIfMissing *TargetTemplate `json:"createIfMissing,omitempty"`
+}
+
+// TargetTemplate is the spec of a target created by the operator
+type TargetTemplate struct {
+	// +kubebuilder:validation:MinItems=1
+	Hosts    []string `json:"hosts"`
+	Gateways []string `json:"gateways,omitempty"`
+	ExportTo []string `json:"exportTo,omitempty"`
+	// DefaultRoute is the http route of the requests no merged route matches
+	DefaultRoute *networkingv1alpha3.HTTPRoute `json:"defaultRoute,omitempty"`
+}
+
+// LabelManaged marks the targets created by the operator
+const LabelManaged = "istiomerger.monime.sl/managed"
+
+// NewTarget returns the target to create for the VirtualServiceMerge
+func (in *TargetTemplate) NewTarget(merge *VirtualServiceMerge) *alpha3.VirtualService {
+	key := merge.TargetKey()
+	target := &alpha3.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    map[string]string{LabelManaged: "true"},
+		},
+	}
+	target.Spec.Hosts = append([]string{}, in.Hosts...)
+	target.Spec.Gateways = append([]string{}, in.Gateways...)
+	target.Spec.ExportTo = append([]string{}, in.ExportTo...)
+	if in.DefaultRoute != nil {
+		target.Spec.Http = []*networkingv1alpha3.HTTPRoute{in.DefaultRoute.DeepCopy()}
+	}
+	return target
 }
 
 func (in *Target) Validate() error {
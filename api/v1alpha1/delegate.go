@@ -0,0 +1,86 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"istio.io/api/networking/v1alpha3"
+	alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Delegates checks if the merge keeps its http routes in a delegate VirtualService
+func (in *VirtualServiceMerge) Delegates() bool {
+	return in.Spec.Mode == MergeModeDelegate
+}
+
+// DelegateKey returns the namespaced name of the delegate VirtualService of the merge
+func (in *VirtualServiceMerge) DelegateKey() types.NamespacedName {
+	return types.NamespacedName{Namespace: in.Namespace, Name: in.Name + "-delegate"}
+}
+
+// NewDelegate returns the delegate VirtualService holding the merge http routes.
+// As Istio requires, it has neither hosts nor gateways.
+func (in *VirtualServiceMerge) NewDelegate(log logr.Logger) *alpha3.VirtualService {
+	key := in.DelegateKey()
+	delegate := &alpha3.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+			Labels:    map[string]string{LabelManaged: "true"},
+		},
+	}
+	delegate.Spec.Http = in.DeepCopy().generateHttpRoutes(log)
+	return delegate
+}
+
+// Delegated returns the merge as it is merged into the target in the Delegate mode: its http
+// routes are replaced with a route delegating the union of their matches to the delegate.
+// The delegating route takes the highest precedence of the routes.
+func (in *VirtualServiceMerge) Delegated(log logr.Logger) *VirtualServiceMerge {
+	out := in.DeepCopy()
+	routes := out.generateHttpRoutes(log)
+	out.Spec.Patch.Http = nil
+	if len(routes) == 0 {
+		return out
+	}
+	key := in.DelegateKey()
+	root := &v1alpha3.HTTPRoute{
+		Delegate: &v1alpha3.Delegate{Name: key.Name, Namespace: key.Namespace},
+	}
+	precedence := 0
+	for _, route := range routes {
+		if len(route.Match) == 0 {
+			// a route matching everything makes the delegating route match everything
+			root.Match = nil
+			break
+		}
+		root.Match = append(root.Match, route.Match...)
+	}
+	for _, route := range routes {
+		if p := merge.RoutePrecedence(route.Name); p > precedence {
+			precedence = p
+		}
+	}
+	root.Name = fmt.Sprintf("%s-delegate-%d", in.Name, precedence)
+	out.Spec.Patch.Http = []*v1alpha3.HTTPRoute{root}
+	return out
+}
@@ -0,0 +1,442 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"istio.io/api/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// ErrMergeForbidden is returned when a merge is not admitted by the policies of its target
+	ErrMergeForbidden       = errors.New("merge forbidden by policy")
+	errEmptyTargetNamespace = errors.New("empty target namespace")
+)
+
+// AnyNamespace matches every namespace in a MergePolicyRule
+const AnyNamespace = "*"
+
+// AnnotationAllowedNamespaces on a target VirtualService which no MergePolicy governs lists, comma separated,
+// the other namespaces whose merges it accepts, or AnyNamespace for all of them
+const AnnotationAllowedNamespaces = "istiomerger.monime.sl/allowed-namespaces"
+
+// PolicyEnforcement decides the fate of a merge with routes violating the policy rules
+type PolicyEnforcement string
+
+const (
+	// EnforcementReject forbids the whole merge
+	EnforcementReject PolicyEnforcement = "Reject"
+	// EnforcementDrop merges the patch without the violating routes
+	EnforcementDrop PolicyEnforcement = "Drop"
+)
+
+// RouteFeature is an http route feature which a MergePolicyRule can deny
+type RouteFeature string
+
+const (
+	FeatureMirror     RouteFeature = "mirror"
+	FeatureFault      RouteFeature = "fault"
+	FeatureRedirect   RouteFeature = "redirect"
+	FeatureRewrite    RouteFeature = "rewrite"
+	FeatureDelegate   RouteFeature = "delegate"
+	FeatureCorsPolicy RouteFeature = "corsPolicy"
+	FeatureHeaders    RouteFeature = "headers"
+)
+
+// MergePolicySpec defines which VirtualServiceMerges may merge into a target
+type MergePolicySpec struct {
+	// Target is the VirtualService governed by this policy. The namespace is required.
+	// +kubebuilder:validation:Required
+	Target Target `json:"target"`
+	// Enforcement decides what happens to a merge with routes violating the rules.
+	// Reject forbids the whole merge while Drop merges it without the violating routes.
+	// Reject wins when several policies govern the target.
+	// +kubebuilder:validation:Enum=Reject;Drop
+	Enforcement PolicyEnforcement `json:"enforcement,omitempty"`
+	// Rules lists who may merge into the target. A route of a merge is admitted
+	// when at least one rule of any policy of the target admits it.
+	Rules []MergePolicyRule `json:"rules,omitempty"`
+}
+
+// MergePolicyRule admits routes from a set of namespaces
+type MergePolicyRule struct {
+	// Namespaces allowed to merge into the target; "*" matches any namespace
+	Namespaces []string `json:"namespaces,omitempty"`
+	// PathPrefixes restricts the uri of the merged http routes to these prefixes, matched on
+	// path segments, and forbids tcp and tls routes. Empty means any uri is allowed.
+	PathPrefixes []string `json:"pathPrefixes,omitempty"`
+	// HeaderNames restricts the headers the merged http routes may match on.
+	// Empty means any header is allowed.
+	HeaderNames []string `json:"headerNames,omitempty"`
+	// DestinationHosts restricts the destinations of the merged routes to the hosts
+	// matching these patterns, e.g. "*.team-a.svc.cluster.local". Empty means any host.
+	DestinationHosts []string `json:"destinationHosts,omitempty"`
+	// DeniedFeatures lists the http route features the merged routes may not use
+	DeniedFeatures []RouteFeature `json:"deniedFeatures,omitempty"`
+	// TargetCreation lets the merges of the namespaces create the missing target from their template.
+	// Only the merges of the target namespace may create it otherwise.
+	TargetCreation *TargetCreationRule `json:"targetCreation,omitempty"`
+}
+
+// TargetCreationRule restricts the templates of the targets created by the merges of a rule
+type TargetCreationRule struct {
+	// Hosts are the patterns, e.g. "*.team-a.example.com", every host of the template must match
+	Hosts []string `json:"hosts,omitempty"`
+	// Gateways the template may bind the target to, as written in the template, e.g. "mesh"
+	Gateways []string `json:"gateways,omitempty"`
+}
+
+// RouteViolation describes a patch route the merge policies do not admit
+// +kubebuilder:object:generate=false
+type RouteViolation struct {
+	// Kind is one of http, tcp or tls
+	Kind string
+	// Index is the position of the route in the patch
+	Index  int
+	Reason string
+}
+
+func (v RouteViolation) String() string {
+	return fmt.Sprintf("%s route %d: %s", v.Kind, v.Index, v.Reason)
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// MergePolicy declares which namespaces and paths may be merged into a VirtualService
+type MergePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MergePolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MergePolicyList contains a list of MergePolicy
+type MergePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MergePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MergePolicy{}, &MergePolicyList{})
+}
+
+func (in *MergePolicySpec) Validate() error {
+	if err := in.Target.Validate(); err != nil {
+		return err
+	}
+	if in.Target.Namespace == "" {
+		return errEmptyTargetNamespace
+	}
+	return nil
+}
+
+// Governs checks if the policy applies to the target of the merge
+func (in *MergePolicy) Governs(merge *VirtualServiceMerge) bool {
+	target := merge.TargetKey()
+	return in.Spec.Target.Name == target.Name && in.Spec.Target.Namespace == target.Namespace
+}
+
+func (in *MergePolicySpec) enforcement() PolicyEnforcement {
+	if in.Enforcement == "" {
+		return EnforcementReject
+	}
+	return in.Enforcement
+}
+
+// Admit evaluates the policies governing the target of the merge. A merge whose target is not governed
+// by any policy is admitted if it is in the target namespace, if the AnnotationAllowedNamespaces of the
+// target, nil when missing, allows its namespace, or if allowUngoverned is true.
+// A governed merge is admitted with the returned violating routes dropped, unless a policy rejects it.
+func (in *MergePolicyList) Admit(merge *VirtualServiceMerge, target metav1.Object, allowUngoverned bool) ([]RouteViolation, error) {
+	var governing []string
+	var rules []*MergePolicyRule
+	drop := true
+	for i := range in.Items {
+		policy := &in.Items[i]
+		if !policy.Governs(merge) {
+			continue
+		}
+		governing = append(governing, policy.Name)
+		for j := range policy.Spec.Rules {
+			rule := &policy.Spec.Rules[j]
+			if rule.allowsNamespace(merge.Namespace) {
+				rules = append(rules, rule)
+				drop = drop && policy.Spec.enforcement() == EnforcementDrop
+			}
+		}
+	}
+	if len(governing) == 0 {
+		key := merge.TargetKey()
+		if allowUngoverned || key.Namespace == merge.Namespace || annotationAllows(target, merge.Namespace) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: no policy or annotation %s of the target allows namespace %q to merge into %s",
+			ErrMergeForbidden, AnnotationAllowedNamespaces, merge.Namespace, key)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%w: namespace %q is not allowed by the policies %v",
+			ErrMergeForbidden, merge.Namespace, governing)
+	}
+	violations := merge.routeViolations(rules)
+	if len(violations) == 0 || drop {
+		return violations, nil
+	}
+	reasons := make([]string, len(violations))
+	for i, v := range violations {
+		reasons[i] = v.String()
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMergeForbidden, strings.Join(reasons, "; "))
+}
+
+// annotationAllows checks if the AnnotationAllowedNamespaces of the target allows the namespace
+func annotationAllows(target metav1.Object, namespace string) bool {
+	if target == nil {
+		return false
+	}
+	for _, ns := range strings.Split(target.GetAnnotations()[AnnotationAllowedNamespaces], ",") {
+		if ns = strings.TrimSpace(ns); ns == AnyNamespace || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// AdmitCreation checks if the merge may create its missing target from its template. A merge of the target
+// namespace may, one of another namespace needs a rule of a policy of the target allowing its namespace to,
+// and the hosts and gateways of the template.
+func (in *MergePolicyList) AdmitCreation(merge *VirtualServiceMerge) error {
+	target := merge.TargetKey()
+	if target.Namespace == merge.Namespace {
+		return nil
+	}
+	var reasons []string
+	for i := range in.Items {
+		policy := &in.Items[i]
+		if !policy.Governs(merge) {
+			continue
+		}
+		for j := range policy.Spec.Rules {
+			rule := &policy.Spec.Rules[j]
+			if rule.TargetCreation == nil || !rule.allowsNamespace(merge.Namespace) {
+				continue
+			}
+			reason := rule.TargetCreation.denial(merge.Spec.Target.CreateIfMissing)
+			if reason == "" {
+				return nil
+			}
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("%w: no policy allows namespace %q to create %s", ErrMergeForbidden, merge.Namespace, target)
+	}
+	return fmt.Errorf("%w: the template of %s %s", ErrMergeForbidden, target, strings.Join(reasons, " or "))
+}
+
+func (in *TargetCreationRule) denial(template *TargetTemplate) string {
+	for _, host := range template.Hosts {
+		allowed := false
+		for _, pattern := range in.Hosts {
+			if ok, _ := path.Match(pattern, host); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("lists the host %q", host)
+		}
+	}
+	for _, gateway := range template.Gateways {
+		allowed := false
+		for _, g := range in.Gateways {
+			allowed = allowed || g == gateway
+		}
+		if !allowed {
+			return fmt.Sprintf("binds the gateway %q", gateway)
+		}
+	}
+	return ""
+}
+
+func (in *VirtualServiceMerge) routeViolations(rules []*MergePolicyRule) []RouteViolation {
+	var violations []RouteViolation
+	check := func(kind string, index int, denial func(rule *MergePolicyRule) string) {
+		var reasons []string
+		for _, rule := range rules {
+			reason := denial(rule)
+			if reason == "" {
+				return
+			}
+			reasons = append(reasons, reason)
+		}
+		violations = append(violations, RouteViolation{Kind: kind, Index: index, Reason: strings.Join(reasons, " or ")})
+	}
+	for i, route := range in.Spec.Patch.Http {
+		check("http", i, func(rule *MergePolicyRule) string {
+			if in.Delegates() && rule.deniesFeature(FeatureDelegate) {
+				return fmt.Sprintf("uses the denied feature %q through the Delegate mode", FeatureDelegate)
+			}
+			return rule.httpDenial(route)
+		})
+	}
+	for i, route := range in.Spec.Patch.Tcp {
+		check("tcp", i, func(rule *MergePolicyRule) string {
+			return rule.l4Denial(route.Route)
+		})
+	}
+	for i, route := range in.Spec.Patch.Tls {
+		check("tls", i, func(rule *MergePolicyRule) string {
+			return rule.l4Denial(route.Route)
+		})
+	}
+	return violations
+}
+
+func (in *MergePolicyRule) httpDenial(route *v1alpha3.HTTPRoute) string {
+	if len(in.PathPrefixes) > 0 {
+		if len(route.Match) == 0 {
+			return "matches all paths"
+		}
+		for _, match := range route.Match {
+			if !in.allowsUri(match.GetUri().GetPrefix()) && !in.allowsUri(match.GetUri().GetExact()) {
+				return fmt.Sprintf("matches a uri outside %v", in.PathPrefixes)
+			}
+		}
+	}
+	if len(in.HeaderNames) > 0 {
+		for _, match := range route.Match {
+			for name := range match.GetHeaders() {
+				if !in.allowsHeader(name) {
+					return fmt.Sprintf("matches the header %q", name)
+				}
+			}
+			for name := range match.GetWithoutHeaders() {
+				if !in.allowsHeader(name) {
+					return fmt.Sprintf("matches the header %q", name)
+				}
+			}
+		}
+	}
+	for _, dest := range route.Route {
+		if !in.allowsHost(dest.GetDestination().GetHost()) {
+			return fmt.Sprintf("routes to the host %q", dest.GetDestination().GetHost())
+		}
+	}
+	if route.Mirror != nil && !in.allowsHost(route.Mirror.Host) {
+		return fmt.Sprintf("mirrors to the host %q", route.Mirror.Host)
+	}
+	for _, feature := range in.DeniedFeatures {
+		if httpRouteUses(route, feature) {
+			return fmt.Sprintf("uses the denied feature %q", feature)
+		}
+	}
+	return ""
+}
+
+func (in *MergePolicyRule) deniesFeature(feature RouteFeature) bool {
+	for _, denied := range in.DeniedFeatures {
+		if denied == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *MergePolicyRule) l4Denial(destinations []*v1alpha3.RouteDestination) string {
+	if len(in.PathPrefixes) > 0 {
+		return "is not an http route while the paths are restricted"
+	}
+	for _, dest := range destinations {
+		if !in.allowsHost(dest.GetDestination().GetHost()) {
+			return fmt.Sprintf("routes to the host %q", dest.GetDestination().GetHost())
+		}
+	}
+	return ""
+}
+
+func httpRouteUses(route *v1alpha3.HTTPRoute, feature RouteFeature) bool {
+	switch feature {
+	case FeatureMirror:
+		return route.Mirror != nil
+	case FeatureFault:
+		return route.Fault != nil
+	case FeatureRedirect:
+		return route.Redirect != nil
+	case FeatureRewrite:
+		return route.Rewrite != nil
+	case FeatureDelegate:
+		return route.Delegate != nil
+	case FeatureCorsPolicy:
+		return route.CorsPolicy != nil
+	case FeatureHeaders:
+		return route.Headers != nil
+	}
+	return false
+}
+
+func (in *MergePolicyRule) allowsNamespace(namespace string) bool {
+	for _, ns := range in.Namespaces {
+		if ns == AnyNamespace || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsUri checks if the uri is under one of the path prefixes, on a path segment
+// boundary so that the prefix /reviews admits /reviews/v1 but not /reviewsadmin
+func (in *MergePolicyRule) allowsUri(uri string) bool {
+	if uri == "" {
+		return false
+	}
+	for _, prefix := range in.PathPrefixes {
+		if uri == prefix || strings.HasPrefix(uri, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *MergePolicyRule) allowsHeader(name string) bool {
+	for _, allowed := range in.HeaderNames {
+		if strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *MergePolicyRule) allowsHost(host string) bool {
+	if len(in.DestinationHosts) == 0 {
+		return true
+	}
+	for _, pattern := range in.DestinationHosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
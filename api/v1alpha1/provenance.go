@@ -0,0 +1,227 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"istio.io/api/networking/v1alpha3"
+	alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
+)
+
+const (
+	// AnnotationRouteSources on a target maps its merged routes to the merges contributing them
+	AnnotationRouteSources = "istiomerger.monime.sl/route-sources"
+	// LabelMergeCount on a target is the number of merges contributing routes to it
+	LabelMergeCount = "istiomerger.monime.sl/merge-count"
+)
+
+// MergeInto returns a copy of the target with the routes of the merge, less the violating ones, merged
+// in or removed, and its route sources updated, as the operator writes the merge into its target.
+// A route of another merge fails it with merge.ErrRouteConflict, along with the report of the conflicts.
+func (in *VirtualServiceMerge) MergeInto(log logr.Logger, target *alpha3.VirtualService,
+	violations []RouteViolation, remove bool) (*alpha3.VirtualService, *merge.Report, error) {
+	patch := in
+	if len(violations) > 0 {
+		patch = patch.WithoutRoutes(log, violations)
+	}
+	sources := TargetRouteSources(target)
+	merged, other := patch, patch.Delegated(log)
+	if patch.Delegates() {
+		merged, other = other, patch
+	}
+	// the http routes of the other mode remain when the mode of the patch changes
+	retired := other.MergePatch()
+	retired.Spec = &v1alpha3.VirtualService{Http: other.Spec.Patch.Http}
+	spec, report, err := merge.MergeSource(&target.Spec, merged.MergePatch(), retired, merge.Options{
+		Remove: remove,
+		Owners: sources.Owners(),
+		Log:    log,
+	})
+	if err != nil {
+		return nil, report, err
+	}
+	if remove {
+		sources.Remove(patch)
+	} else {
+		sources.Set(patch, merged.RouteIds(log))
+	}
+	result := target.DeepCopy()
+	result.Spec = *spec
+	sources.Apply(result)
+	return result, report, nil
+}
+
+// RouteSources maps the routes of a target, identified as in TargetDiff, to the merges contributing them
+type RouteSources map[string]MergeVersion
+
+// TargetRouteSources reads the route sources of the target; an unreadable annotation is started over
+func TargetRouteSources(target *alpha3.VirtualService) RouteSources {
+	sources := RouteSources{}
+	if value, ok := target.Annotations[AnnotationRouteSources]; ok {
+		if err := json.Unmarshal([]byte(value), &sources); err != nil {
+			return RouteSources{}
+		}
+	}
+	return sources
+}
+
+// Set records the merge as the source of the routes, replacing its previous routes
+func (s RouteSources) Set(merge *VirtualServiceMerge, routes []string) {
+	s.Remove(merge)
+	version := merge.Version()
+	for _, route := range routes {
+		s[route] = version
+	}
+}
+
+// Remove forgets the routes of the merge
+func (s RouteSources) Remove(merge *VirtualServiceMerge) {
+	for route, source := range s {
+		if source.Namespace == merge.Namespace && source.Name == merge.Name {
+			delete(s, route)
+		}
+	}
+}
+
+// Owners returns the merges contributing the routes, identified as the sources of their merge patches
+func (s RouteSources) Owners() map[string]string {
+	owners := make(map[string]string, len(s))
+	for route, source := range s {
+		owners[route] = source.Namespace + "/" + source.Name
+	}
+	return owners
+}
+
+// Apply writes the sources of the routes still in the target to its annotation,
+// along with the number of merges in its label
+func (s RouteSources) Apply(target *alpha3.VirtualService) {
+	present := map[string]bool{}
+	for _, id := range merge.RouteIds(&target.Spec) {
+		present[id] = true
+	}
+	merges := map[string]bool{}
+	for route, source := range s {
+		if !present[route] {
+			delete(s, route)
+			continue
+		}
+		merges[source.Namespace+"/"+source.Name] = true
+	}
+	if len(s) == 0 {
+		delete(target.Annotations, AnnotationRouteSources)
+		delete(target.Labels, LabelMergeCount)
+		return
+	}
+	// a map of strings always encodes
+	data, _ := json.Marshal(s)
+	if target.Annotations == nil {
+		target.Annotations = map[string]string{}
+	}
+	if target.Labels == nil {
+		target.Labels = map[string]string{}
+	}
+	target.Annotations[AnnotationRouteSources] = string(data)
+	target.Labels[LabelMergeCount] = strconv.Itoa(len(merges))
+}
+
+// Version identifies the current version of the merge
+func (in *VirtualServiceMerge) Version() MergeVersion {
+	return MergeVersion{Namespace: in.Namespace, Name: in.Name, UID: in.UID, Generation: in.Generation}
+}
+
+// MergedRouteIds returns the ids of the routes the merge writes into its target in its mode
+func (in *VirtualServiceMerge) MergedRouteIds(log logr.Logger) []string {
+	if in.Delegates() {
+		return in.Delegated(log).RouteIds(log)
+	}
+	return in.DeepCopy().RouteIds(log)
+}
+
+// CheckRouteOwners forbids the merge from writing a route of the same id as a route of
+// another merge of the same target, unless the merge is the oldest of them
+func (in *VirtualServiceMerge) CheckRouteOwners(merges []VirtualServiceMerge) error {
+	ids := map[string]bool{}
+	for _, id := range in.MergedRouteIds(logr.Discard()) {
+		ids[id] = true
+	}
+	var claimed []string
+	for i := range merges {
+		other := &merges[i]
+		if other.Namespace == in.Namespace && other.Name == in.Name ||
+			other.TargetKey() != in.TargetKey() || !other.olderThan(in) {
+			continue
+		}
+		for _, id := range other.MergedRouteIds(logr.Discard()) {
+			if ids[id] {
+				claimed = append(claimed, fmt.Sprintf("%s belongs to the merge %s/%s", id, other.Namespace, other.Name))
+			}
+		}
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+	sort.Strings(claimed)
+	return fmt.Errorf("%w: the route %s", ErrMergeForbidden, strings.Join(claimed, ", the route "))
+}
+
+// olderThan checks if the merge was created before the other one, a merge not created yet being the newest
+func (in *VirtualServiceMerge) olderThan(other *VirtualServiceMerge) bool {
+	switch {
+	case in.CreationTimestamp.IsZero():
+		return false
+	case other.CreationTimestamp.IsZero():
+		return true
+	case !in.CreationTimestamp.Equal(&other.CreationTimestamp):
+		return in.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return in.Namespace+"/"+in.Name < other.Namespace+"/"+other.Name
+}
+
+// RouteIds returns the ids, as in TargetDiff, of the routes the merge contributes to its target.
+// The tcp and tls routes without ports are always appended to the target so they are left out.
+func (in *VirtualServiceMerge) RouteIds(log logr.Logger) []string {
+	var ids []string
+	for _, route := range in.generateHttpRoutes(log) {
+		ids = append(ids, "http/"+route.Name)
+	}
+	for _, route := range in.Spec.Patch.Tcp {
+		var ports []uint32
+		for _, match := range route.Match {
+			ports = append(ports, match.Port)
+		}
+		if len(ports) > 0 {
+			ids = append(ids, "tcp/"+merge.PortsId(ports))
+		}
+	}
+	for _, route := range in.Spec.Patch.Tls {
+		var ports []uint32
+		for _, match := range route.Match {
+			ports = append(ports, match.Port)
+		}
+		if len(ports) > 0 {
+			ids = append(ids, "tls/"+merge.PortsId(ports))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"istio.io/api/networking/v1alpha3"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// LabelRevisionTarget labels the ControllerRevisions of a target with its name
+	LabelRevisionTarget = "istiomerger.monime.sl/target"
+	// AnnotationPinnedRevision on a target pins it to one of its revisions;
+	// the merges into it are paused until the annotation is removed
+	AnnotationPinnedRevision = "istiomerger.monime.sl/pinned-revision"
+	// AnnotationRemerge on a merge asks for merging it again into its target, even if it was applied
+	// and drift correction is disabled; it is set when the target is unpinned and removed once merged
+	AnnotationRemerge = "istiomerger.monime.sl/remerge"
+)
+
+// TargetRevision is the content of a ControllerRevision of a target
+// +kubebuilder:object:generate=false
+type TargetRevision struct {
+	// Spec is the target spec as written by the operator
+	Spec *v1alpha3.VirtualService `json:"spec"`
+	// Merges are the versions of the merges into the target at that time
+	Merges []MergeVersion `json:"merges,omitempty"`
+}
+
+// MergeVersion identifies a version of a VirtualServiceMerge
+// +kubebuilder:object:generate=false
+type MergeVersion struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+	Generation int64     `json:"generation"`
+}
+
+func (v MergeVersion) String() string {
+	return fmt.Sprintf("%s/%s@%d", v.Namespace, v.Name, v.Generation)
+}
+
+// NewTargetRevision records the target spec along with the merges into it
+func NewTargetRevision(spec *v1alpha3.VirtualService, merges []VirtualServiceMerge) *TargetRevision {
+	revision := &TargetRevision{Spec: spec}
+	for i := range merges {
+		revision.Merges = append(revision.Merges, merges[i].Version())
+	}
+	sort.Slice(revision.Merges, func(i, j int) bool {
+		return revision.Merges[i].String() < revision.Merges[j].String()
+	})
+	return revision
+}
+
+// DecodeTargetRevision reads the content of a ControllerRevision of a target
+func DecodeTargetRevision(revision *appsv1.ControllerRevision) (*TargetRevision, error) {
+	out := &TargetRevision{}
+	if err := json.Unmarshal(revision.Data.Raw, out); err != nil {
+		return nil, fmt.Errorf("decoding the revision %s: %w", revision.Name, err)
+	}
+	if out.Spec == nil {
+		return nil, fmt.Errorf("decoding the revision %s: no spec", revision.Name)
+	}
+	return out, nil
+}
+
+// RevisionName names the revision of the target spec after its hash, so that
+// a spec written again reuses its revision
+func RevisionName(target string, spec *v1alpha3.VirtualService) (string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write(data)
+	return fmt.Sprintf("%s-%s", target, rand.SafeEncodeString(fmt.Sprint(hash.Sum32()))), nil
+}
+
+// TargetRevisions returns the revisions owned by the target, the oldest first
+func TargetRevisions(revisions []appsv1.ControllerRevision, target metav1.Object) []appsv1.ControllerRevision {
+	var owned []appsv1.ControllerRevision
+	for _, revision := range revisions {
+		for _, owner := range revision.OwnerReferences {
+			if owner.UID == target.GetUID() {
+				owned = append(owned, revision)
+				break
+			}
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Revision < owned[j].Revision })
+	return owned
+}
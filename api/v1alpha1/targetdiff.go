@@ -0,0 +1,68 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+// MaxDiffEntries bounds every list of the diff recorded in the status
+const MaxDiffEntries = 32
+
+// TargetDiff describes the change a merge made to the target spec. The routes are identified
+// by their kind and name, e.g. "http/reviews-1", or by their ports for the unnamed tcp and tls routes.
+type TargetDiff struct {
+	// Added lists the routes added to the target
+	Added []string `json:"added,omitempty"`
+	// Replaced lists the routes of the target whose content changed
+	Replaced []RouteChange `json:"replaced,omitempty"`
+	// Removed lists the routes removed from the target
+	Removed []string `json:"removed,omitempty"`
+	// Reordered lists the routes kept in the target at another position relative to each other
+	Reordered []string `json:"reordered,omitempty"`
+	// Truncated is set when the lists were cut to MaxDiffEntries
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// RouteChange lists the changed fields of a replaced route
+type RouteChange struct {
+	Route string `json:"route"`
+	// Fields are the paths of the changed fields, e.g. "route[0].destination.host"
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Empty checks if the merge left the target routes unchanged
+func (in *TargetDiff) Empty() bool {
+	return len(in.Added) == 0 && len(in.Replaced) == 0 && len(in.Removed) == 0 && len(in.Reordered) == 0
+}
+
+// Bounded returns a copy of the diff whose lists have at most max entries
+func (in *TargetDiff) Bounded(max int) *TargetDiff {
+	out := in.DeepCopy()
+	bound := func(list []string) []string {
+		if len(list) > max {
+			out.Truncated = true
+			return list[:max]
+		}
+		return list
+	}
+	out.Added, out.Removed, out.Reordered = bound(out.Added), bound(out.Removed), bound(out.Reordered)
+	if len(out.Replaced) > max {
+		out.Truncated = true
+		out.Replaced = out.Replaced[:max]
+	}
+	for i := range out.Replaced {
+		out.Replaced[i].Fields = bound(out.Replaced[i].Fields)
+	}
+	return out
+}
@@ -32,4 +32,22 @@ type VirtualServiceMergeSpec struct {
 	Target Target `json:"target"`
 	// +kubebuilder:validation:Required
 	Patch networkingv1alpha3.VirtualService `json:"patch"`
+	// DryRun computes the merge and validates it with the API server without changing the target.
+	// The change the patch would make is reported in the status instead.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Mode is how the patch http routes reach the target: copied into it (Merge), or kept in
+	// a delegate VirtualService which a single route of the target delegates to (Delegate).
+	// +kubebuilder:validation:Enum=Merge;Delegate
+	// +kubebuilder:default=Merge
+	Mode MergeMode `json:"mode,omitempty"`
 }
+
+// MergeMode is how the patch http routes reach the target
+type MergeMode string
+
+const (
+	// MergeModeMerge copies the patch routes into the target
+	MergeModeMerge MergeMode = "Merge"
+	// MergeModeDelegate moves the patch http routes into a delegate VirtualService
+	MergeModeDelegate MergeMode = "Delegate"
+)
@@ -16,8 +16,61 @@
 
 package v1alpha1
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ConditionApplied reports whether the patch is merged into the target
+	ConditionApplied = "Applied"
+
+	// ReasonApplied is set when the patch is merged into the target
+	ReasonApplied = "Applied"
+	// ReasonForbidden is set when the merge policies of the target reject the patch
+	ReasonForbidden = "Forbidden"
+	// ReasonRoutesDropped is set when the patch is merged without the routes its target policies drop
+	ReasonRoutesDropped = "RoutesDropped"
+	// ReasonTargetMissing is set while the target does not exist
+	ReasonTargetMissing = "TargetMissing"
+	// ReasonPaused is set while the target is pinned to one of its revisions
+	ReasonPaused = "Paused"
+	// ReasonDryRun is set when the merge is computed without changing the target
+	ReasonDryRun = "DryRun"
+	// ReasonDelegateTaken is set when the name of the delegate VirtualService of the patch is taken by another object
+	ReasonDelegateTaken = "DelegateTaken"
+
+	// MaxDryRunDiffLength bounds the length of the diff reported in the status of a dry run
+	MaxDryRunDiffLength = 4096
+)
+
 // VirtualServicePatchStatus defines the observed state of VirtualServiceMerge
 type VirtualServicePatchStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+
+	// Deprecated: replaced by ObservedGeneration and Target
 	HandledRevision string `json:"HandledRevision,omitempty"`
+	// ObservedGeneration is the generation of the VirtualServiceMerge last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Target identifies the target the patch was last merged into
+	Target *TargetStatus `json:"target,omitempty"`
+	// DryRunDiff is the unified diff the patch would make to the target spec in a dry run,
+	// truncated to MaxDryRunDiffLength
+	DryRunDiff string `json:"dryRunDiff,omitempty"`
+	// LastDiff describes the last change the patch made to the target,
+	// with lists bounded to MaxDiffEntries
+	LastDiff *TargetDiff `json:"lastDiff,omitempty"`
+	// ReconcileID identifies the reconcile which last updated the status in the operator logs and events
+	ReconcileID string `json:"reconcileID,omitempty"`
+	// Conditions represent the latest observations of the merge state
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TargetStatus identifies a target VirtualService at the time the patch was merged into it.
+// A target recreated or changed since then has a different UID or generation.
+type TargetStatus struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+	Generation int64     `json:"generation"`
 }
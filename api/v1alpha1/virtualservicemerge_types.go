@@ -18,14 +18,11 @@ package v1alpha1
 
 import (
 	"fmt"
-	"github.com/monimesl/operator-helper/reconciler"
-	"go.uber.org/zap"
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
 	"istio.io/api/networking/v1alpha3"
-	alpha3 "istio.io/client-go/pkg/apis/networking/v1alpha3"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sort"
-	"strconv"
-	"strings"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // +kubebuilder:object:root=true
@@ -52,167 +49,59 @@ type VirtualServiceMerge struct {
 	Status VirtualServicePatchStatus `json:"status,omitempty"`
 }
 
-func (in *VirtualServiceMerge) AddTcpRoutes(target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Tcp
-outer:
-	for _, pRoute := range in.Spec.Patch.Tcp {
-		for i, tRoute := range targetRoutes {
-			if tcpMatchesEqual(tRoute.Match, pRoute.Match) {
-				targetRoutes[i] = pRoute // replace
-				continue outer
-			}
-		}
-		// add
-		targetRoutes = append(targetRoutes, pRoute)
-	}
-	target.Spec.Tcp = targetRoutes
-}
-
-func (in *VirtualServiceMerge) RemoveTcpRoutes(target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Tcp
-outer:
-	for _, pRoute := range in.Spec.Patch.Tcp {
-		for i, tRoute := range targetRoutes {
-			if tcpMatchesEqual(tRoute.Match, pRoute.Match) {
-				// remove the route
-				targetRoutes = append(targetRoutes[0:i], targetRoutes[i+1:]...)
-				continue outer
-			}
-		}
-	}
-	target.Spec.Tcp = targetRoutes
-}
-
-func tcpMatchesEqual(sourceMatches []*v1alpha3.L4MatchAttributes, match2 []*v1alpha3.L4MatchAttributes) bool {
-	for _, sM := range sourceMatches {
-		for _, cM := range match2 {
-			if sM.Port == cM.Port {
-				// we treat port equality as equal
-				return true
-			}
-		}
-	}
-	return false
-}
-
-func (in *VirtualServiceMerge) AddTlsRoutes(target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Tls
-outer:
-	for _, pRoute := range in.Spec.Patch.Tls {
-		for i, tRoute := range targetRoutes {
-			if tlsMatchesEqual(tRoute.Match, pRoute.Match) {
-				targetRoutes[i] = pRoute // replace
-				continue outer
-			}
-		}
-		// add
-		targetRoutes = append(targetRoutes, pRoute)
+// TargetKey returns the namespaced name of the target, defaulting
+// the namespace to the one of the VirtualServiceMerge
+func (in *VirtualServiceMerge) TargetKey() types.NamespacedName {
+	namespace := in.Spec.Target.Namespace
+	if namespace == "" {
+		namespace = in.Namespace
 	}
-	target.Spec.Tls = targetRoutes
+	return types.NamespacedName{Namespace: namespace, Name: in.Spec.Target.Name}
 }
 
-func (in *VirtualServiceMerge) RemoveTlsRoutes(target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Tls
-outer:
-	for _, pRoute := range in.Spec.Patch.Tls {
-		for i, tRoute := range targetRoutes {
-			if tlsMatchesEqual(tRoute.Match, pRoute.Match) {
-				// remove the route
-				targetRoutes = append(targetRoutes[0:i], targetRoutes[i+1:]...)
-				continue outer
-			}
-		}
+// WithoutRoutes returns a copy of the merge without the violating routes. The http routes
+// are named before being dropped so that the names of the remaining ones are kept.
+func (in *VirtualServiceMerge) WithoutRoutes(log logr.Logger, violations []RouteViolation) *VirtualServiceMerge {
+	out := in.DeepCopy()
+	out.generateHttpRoutes(log)
+	dropped := make(map[string]bool, len(violations))
+	for _, v := range violations {
+		dropped[fmt.Sprintf("%s/%d", v.Kind, v.Index)] = true
 	}
-	target.Spec.Tls = targetRoutes
-}
-
-func tlsMatchesEqual(sourceMatches []*v1alpha3.TLSMatchAttributes, match2 []*v1alpha3.TLSMatchAttributes) bool {
-	for _, sM := range sourceMatches {
-		for _, cM := range match2 {
-			if sM.Port == cM.Port {
-				// we treat port equality as equal
-				return true
-			}
+	http := out.Spec.Patch.Http[:0]
+	for i, route := range out.Spec.Patch.Http {
+		if !dropped[fmt.Sprintf("http/%d", i)] {
+			http = append(http, route)
 		}
 	}
-	return false
-}
-
-func (in *VirtualServiceMerge) AddHttpRoutes(ctx reconciler.Context, target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Http
-	patchRoutes := in.generateHttpRoutes(ctx)
-outer:
-	for _, pRoute := range patchRoutes {
-		for i, tRoute := range targetRoutes {
-			if tRoute.Name == pRoute.Name {
-				targetRoutes[i] = pRoute // replace
-				continue outer
-			}
+	tcp := out.Spec.Patch.Tcp[:0]
+	for i, route := range out.Spec.Patch.Tcp {
+		if !dropped[fmt.Sprintf("tcp/%d", i)] {
+			tcp = append(tcp, route)
 		}
-		// add - prepend to the slice just so that the new route is above
-		// the "default", i.e. no a matchspec route already in the targeted vs.
-		targetRoutes = append(targetRoutes, pRoute)
-		copy(targetRoutes[1:], targetRoutes)
-		targetRoutes[0] = pRoute
 	}
-	target.Spec.Http = sanitizeRoutes(ctx, targetRoutes)
-}
-
-func (in *VirtualServiceMerge) RemoveHttpRoutes(ctx reconciler.Context, target *alpha3.VirtualService) {
-	targetRoutes := target.Spec.Http
-	patchRoutes := in.generateHttpRoutes(ctx)
-outer:
-	for _, pRoute := range patchRoutes {
-		for i, tRoute := range targetRoutes {
-			if tRoute.Name == pRoute.Name {
-				// remove the route
-				targetRoutes = append(targetRoutes[0:i], targetRoutes[i+1:]...)
-				continue outer
-			}
+	tls := out.Spec.Patch.Tls[:0]
+	for i, route := range out.Spec.Patch.Tls {
+		if !dropped[fmt.Sprintf("tls/%d", i)] {
+			tls = append(tls, route)
 		}
 	}
-	target.Spec.Http = sanitizeRoutes(ctx, targetRoutes)
+	out.Spec.Patch.Http, out.Spec.Patch.Tcp, out.Spec.Patch.Tls = http, tcp, tls
+	return out
 }
 
-func sanitizeRoutes(ctx reconciler.Context, routes []*v1alpha3.HTTPRoute) []*v1alpha3.HTTPRoute {
-	sort.SliceStable(routes, func(i, j int) bool {
-		_, iPrecedence, _ := parsePrecedence(ctx, routes[i].Name)
-		_, jPrecedence, _ := parsePrecedence(ctx, routes[j].Name)
-		return iPrecedence > jPrecedence
-	})
-	return routes
+// MergePatch returns the patch of the merge as the merge library takes it
+func (in *VirtualServiceMerge) MergePatch() merge.Patch {
+	return merge.Patch{Name: in.Name, Source: in.Namespace + "/" + in.Name, Spec: &in.Spec.Patch}
 }
 
-func parsePrecedence(ctx reconciler.Context, name string) (string, int, bool) {
-	parts := strings.Split(name, "-")
-	if len(parts) <= 1 {
-		return name, 0, false
-	}
-	precedenceStr := parts[len(parts)-1]
-	precedence, err := strconv.ParseInt(precedenceStr, 10, 64)
-	if err != nil {
-		ctx.Logger().Info("No precedence for route. Defaulting to 0", "route", name)
-		return name, 0, false
-	}
-	return strings.Join(parts[:len(parts)-1], "-"), int(precedence), true
+// HttpRoutes returns the patch http routes named as they are merged into the target
+func (in *VirtualServiceMerge) HttpRoutes(log logr.Logger) []*v1alpha3.HTTPRoute {
+	return in.generateHttpRoutes(log)
 }
 
-func (in *VirtualServiceMerge) generateHttpRoutes(ctx reconciler.Context) []*v1alpha3.HTTPRoute {
-	routes := make([]*v1alpha3.HTTPRoute, len(in.Spec.Patch.Http))
-	routesCount := len(in.Spec.Patch.Http)
-	for i, r := range in.Spec.Patch.Http {
-		name := r.Name
-		if r.Name == "" {
-			// make the precedence decrease as we go down the list.
-			precedence := int64(routesCount - i - 1)
-			r.Name = fmt.Sprintf("%s-%d", in.Name, precedence)
-		} else if _, _, ok := parsePrecedence(ctx, r.Name); !ok {
-			// make the precedence decrease as we go down the list.
-			precedence := int64(routesCount - i - 1)
-			r.Name = fmt.Sprintf("%s-%d", in.Name, precedence)
-		}
-		routes[i] = r
-		zap.S().Info("The patch '%s' route '%s' rewritten to '%s'", in.Name, name, r.Name)
-	}
-	return routes
+// generateHttpRoutes names the patch http routes as they are merged into the target
+func (in *VirtualServiceMerge) generateHttpRoutes(log logr.Logger) []*v1alpha3.HTTPRoute {
+	in.Spec.Patch.Http = merge.NameHttpRoutes(log, in.Name, in.Spec.Patch.Http)
+	return in.Spec.Patch.Http
 }
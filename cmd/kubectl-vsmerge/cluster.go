@@ -0,0 +1,106 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(istio.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
+// clusterFlags are the flags selecting the cluster and the namespace to inspect
+type clusterFlags struct {
+	kubeconfig    string
+	context       string
+	namespace     string
+	allNamespaces bool
+}
+
+func (f *clusterFlags) register(flags *flag.FlagSet, allNamespaces bool) {
+	flags.StringVar(&f.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file")
+	flags.StringVar(&f.context, "context", "", "The kubeconfig context to use")
+	flags.StringVar(&f.namespace, "n", "", "The namespace; the one of the kubeconfig context by default")
+	if allNamespaces {
+		flags.BoolVar(&f.allNamespaces, "A", false, "Inspect all the namespaces")
+	}
+}
+
+// client creates the cluster client and resolves the namespace
+func (f *clusterFlags) client() (client.Client, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = f.kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: f.context})
+	if f.namespace == "" {
+		namespace, _, err := config.Namespace()
+		if err != nil {
+			return nil, err
+		}
+		f.namespace = namespace
+	}
+	if f.allNamespaces {
+		f.namespace = ""
+	}
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(restConfig, client.Options{Scheme: scheme})
+}
+
+// listMerges lists the merges of every namespace
+func listMerges(c client.Client) ([]v1alpha1.VirtualServiceMerge, error) {
+	merges := &v1alpha1.VirtualServiceMergeList{}
+	if err := c.List(context.TODO(), merges); err != nil {
+		return nil, fmt.Errorf("listing the VirtualServiceMerges: %w", err)
+	}
+	return merges.Items, nil
+}
+
+func newTable(w io.Writer, header ...interface{}) *tabwriter.Writer {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	printRow(table, header...)
+	return table
+}
+
+func printRow(w io.Writer, columns ...interface{}) {
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, column)
+	}
+	fmt.Fprintln(w)
+}
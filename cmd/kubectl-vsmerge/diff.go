@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	textdiff "github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	mergelib "github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func diff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, false)
+	var contextLines int
+	var allowUngoverned bool
+	flags.IntVar(&contextLines, "U", 3, "The number of unchanged lines shown around the changes")
+	flags.BoolVar(&allowUngoverned, "allow-ungoverned-cross-namespace", false, "Evaluate the policies as the operator started with -allow-ungoverned-cross-namespace")
+	names := cli.ParseInterspersed(flags, args)
+	if len(names) != 1 {
+		return errors.New("expects the name of a VirtualServiceMerge")
+	}
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	return diffMerge(os.Stdout, c, types.NamespacedName{Namespace: cluster.namespace, Name: names[0]},
+		allowUngoverned, contextLines)
+}
+
+// diffMerge prints what applying and removing the merge change on its target, written as the operator writes them
+func diffMerge(w io.Writer, c client.Client, key types.NamespacedName, allowUngoverned bool, contextLines int) error {
+	merge := &v1alpha1.VirtualServiceMerge{}
+	if err := c.Get(context.TODO(), key, merge); err != nil {
+		return err
+	}
+	target := &istio.VirtualService{}
+	if err := c.Get(context.TODO(), merge.TargetKey(), target); err != nil {
+		return fmt.Errorf("getting the target %s: %w", merge.TargetKey(), err)
+	}
+	policies := &v1alpha1.MergePolicyList{}
+	if err := c.List(context.TODO(), policies); err != nil {
+		return fmt.Errorf("listing the MergePolicies: %w", err)
+	}
+	current, err := textdiff.Marshal(&target.Spec, "yaml")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Applying %s/%s to %s:\n", merge.Namespace, merge.Name, merge.TargetKey())
+	violations, err := policies.Admit(merge, target, allowUngoverned)
+	if err != nil {
+		fmt.Fprintf(w, "  %s\n", err)
+	} else {
+		for _, v := range violations {
+			fmt.Fprintf(w, "  dropping %s\n", v)
+		}
+		applied, report, err := merge.MergeInto(logr.Discard(), target, violations, false)
+		switch {
+		case errors.Is(err, mergelib.ErrRouteConflict):
+			for _, conflict := range report.Conflicts {
+				fmt.Fprintf(w, "  forbidden: %s belongs to %s\n", conflict.Route, conflict.Sources[0])
+			}
+		case err != nil:
+			return err
+		default:
+			if err := printDiff(w, current, &applied.Spec, contextLines); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\nRemoving %s/%s from %s:\n", merge.Namespace, merge.Name, merge.TargetKey())
+	// the operator removes the merge less the routes its policies dropped
+	removed, _, err := merge.MergeInto(logr.Discard(), target, violations, true)
+	if err != nil {
+		return err
+	}
+	return printDiff(w, current, &removed.Spec, contextLines)
+}
+
+func printDiff(w io.Writer, current []byte, spec interface{}, contextLines int) error {
+	changed, err := textdiff.Marshal(spec, "yaml")
+	if err != nil {
+		return err
+	}
+	if d := textdiff.Lines(string(current), string(changed), contextLines); d != "" {
+		_, err = fmt.Fprint(w, d)
+		return err
+	}
+	_, err = fmt.Fprintln(w, "  no change")
+	return err
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	mergelib "github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func explain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, false)
+	names := cli.ParseInterspersed(flags, args)
+	if len(names) != 1 {
+		return errors.New("expects the name of a VirtualService")
+	}
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	return explainTarget(os.Stdout, c, types.NamespacedName{Namespace: cluster.namespace, Name: names[0]})
+}
+
+// explainTarget prints the routes of the VirtualService with the merges contributing them
+func explainTarget(w io.Writer, c client.Client, key types.NamespacedName) error {
+	vs := &istio.VirtualService{}
+	if err := c.Get(context.TODO(), key, vs); err != nil {
+		return err
+	}
+	merges, err := listMerges(c)
+	if err != nil {
+		return err
+	}
+	var contributing []*v1alpha1.VirtualServiceMerge
+	httpOwners := map[string]*v1alpha1.VirtualServiceMerge{}
+	for i := range merges {
+		merge := &merges[i]
+		if merge.TargetKey() != key {
+			continue
+		}
+		contributing = append(contributing, merge)
+		for _, route := range merge.HttpRoutes(logr.Discard()) {
+			httpOwners[route.Name] = merge
+		}
+	}
+	sort.Slice(contributing, func(i, j int) bool {
+		return mergeName(contributing[i]) < mergeName(contributing[j])
+	})
+
+	// the operator records the merge writing each route, the merges naming it may not all have
+	sources := v1alpha1.TargetRouteSources(vs).Owners()
+	table := newTable(w, "#", "KIND", "NAME", "MATCH", "PRECEDENCE", "MERGE")
+	for i, route := range vs.Spec.Http {
+		owner, recorded := sources["http/"+route.Name]
+		if !recorded {
+			owner = mergeName(httpOwners[route.Name])
+		}
+		printRow(table, i, "http", orNone(route.Name), describeHttpMatch(route.Match),
+			mergelib.RoutePrecedence(route.Name), owner)
+	}
+	for i, route := range vs.Spec.Tcp {
+		var owner *v1alpha1.VirtualServiceMerge
+		for _, merge := range contributing {
+			for _, patchRoute := range merge.Spec.Patch.Tcp {
+				if mergelib.SameTcpRoute(route, patchRoute) {
+					owner = merge
+				}
+			}
+		}
+		printRow(table, i, "tcp", "-", describeL4Match(len(route.Match), func(j int) uint32 {
+			return route.Match[j].Port
+		}), "-", mergeName(owner))
+	}
+	for i, route := range vs.Spec.Tls {
+		var owner *v1alpha1.VirtualServiceMerge
+		for _, merge := range contributing {
+			for _, patchRoute := range merge.Spec.Patch.Tls {
+				if mergelib.SameTlsRoute(route, patchRoute) {
+					owner = merge
+				}
+			}
+		}
+		printRow(table, i, "tls", "-", describeL4Match(len(route.Match), func(j int) uint32 {
+			return route.Match[j].Port
+		}), "-", mergeName(owner))
+	}
+	return table.Flush()
+}
+
+func mergeName(merge *v1alpha1.VirtualServiceMerge) string {
+	if merge == nil {
+		return "-"
+	}
+	return merge.Namespace + "/" + merge.Name
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func describeHttpMatch(matches []*v1alpha3.HTTPMatchRequest) string {
+	if len(matches) == 0 {
+		return "*"
+	}
+	described := make([]string, 0, len(matches))
+	for _, match := range matches {
+		var parts []string
+		if uri := match.GetUri(); uri != nil {
+			parts = append(parts, "uri "+describeStringMatch(uri))
+		}
+		if method := match.GetMethod(); method != nil {
+			parts = append(parts, "method "+describeStringMatch(method))
+		}
+		headers := make([]string, 0, len(match.GetHeaders()))
+		for name := range match.GetHeaders() {
+			headers = append(headers, name)
+		}
+		sort.Strings(headers)
+		for _, name := range headers {
+			parts = append(parts, "header "+name)
+		}
+		if match.GetPort() != 0 {
+			parts = append(parts, fmt.Sprintf("port %d", match.GetPort()))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "*")
+		}
+		described = append(described, strings.Join(parts, " "))
+	}
+	return strings.Join(described, " | ")
+}
+
+func describeStringMatch(match *v1alpha3.StringMatch) string {
+	switch {
+	case match.GetPrefix() != "":
+		return "prefix " + match.GetPrefix()
+	case match.GetExact() != "":
+		return "exact " + match.GetExact()
+	case match.GetRegex() != "":
+		return "regex " + match.GetRegex()
+	}
+	return "*"
+}
+
+func describeL4Match(count int, port func(i int) uint32) string {
+	if count == 0 {
+		return "*"
+	}
+	ports := make([]string, count)
+	for i := range ports {
+		ports[i] = fmt.Sprintf("port %d", port(i))
+	}
+	return strings.Join(ports, " | ")
+}
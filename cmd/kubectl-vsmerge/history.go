@@ -0,0 +1,183 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, false)
+	var revision int64
+	flags.Int64Var(&revision, "revision", 0, "Print the spec of this revision instead of listing them")
+	names := cli.ParseInterspersed(flags, args)
+	if len(names) != 1 {
+		return errors.New("expects the name of a VirtualService")
+	}
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	target, revisions, err := targetRevisions(c, types.NamespacedName{Namespace: cluster.namespace, Name: names[0]})
+	if err != nil {
+		return err
+	}
+	if revision > 0 {
+		content, err := findRevision(revisions, revision)
+		if err != nil {
+			return err
+		}
+		return cli.WriteObject(os.Stdout, content.Spec, "yaml")
+	}
+	pinned := target.Annotations[v1alpha1.AnnotationPinnedRevision]
+	table := newTable(os.Stdout, "REVISION", "CREATED", "PINNED", "MERGES")
+	for i := range revisions {
+		content, err := v1alpha1.DecodeTargetRevision(&revisions[i])
+		if err != nil {
+			return err
+		}
+		merges := make([]string, len(content.Merges))
+		for j, merge := range content.Merges {
+			merges[j] = merge.String()
+		}
+		mark := ""
+		if strconv.FormatInt(revisions[i].Revision, 10) == pinned {
+			mark = "yes"
+		}
+		printRow(table, revisions[i].Revision, revisions[i].CreationTimestamp.Format("2006-01-02 15:04:05"),
+			mark, strings.Join(merges, ","))
+	}
+	return table.Flush()
+}
+
+func rollback(args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, false)
+	var revision int64
+	var unpin bool
+	flags.Int64Var(&revision, "to-revision", 0, "The revision to restore and pin the VirtualService to")
+	flags.BoolVar(&unpin, "unpin", false, "Unpin the VirtualService so that the merges are applied again")
+	names := cli.ParseInterspersed(flags, args)
+	if len(names) != 1 {
+		return errors.New("expects the name of a VirtualService")
+	}
+	if (revision > 0) == unpin {
+		return errors.New("expects either -to-revision or -unpin")
+	}
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	target, revisions, err := targetRevisions(c, types.NamespacedName{Namespace: cluster.namespace, Name: names[0]})
+	if err != nil {
+		return err
+	}
+	if unpin {
+		delete(target.Annotations, v1alpha1.AnnotationPinnedRevision)
+		if err := c.Update(context.TODO(), target); err != nil {
+			return err
+		}
+		// the merges which never saw the pin still hold as applied; ask for merging them all again
+		count, err := requestRemerge(c, types.NamespacedName{Namespace: target.Namespace, Name: target.Name})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s/%s unpinned; its %d merges are applied again\n", target.Namespace, target.Name, count)
+		return nil
+	}
+	content, err := findRevision(revisions, revision)
+	if err != nil {
+		return err
+	}
+	target.Spec = *content.Spec
+	if target.Annotations == nil {
+		target.Annotations = map[string]string{}
+	}
+	target.Annotations[v1alpha1.AnnotationPinnedRevision] = strconv.FormatInt(revision, 10)
+	if err := c.Update(context.TODO(), target); err != nil {
+		return err
+	}
+	fmt.Printf("%s/%s rolled back and pinned to the revision %d; the merges are paused until it is unpinned\n",
+		target.Namespace, target.Name, revision)
+	return nil
+}
+
+// requestRemerge annotates the merges into the target so that the operator merges them again
+func requestRemerge(c client.Client, key types.NamespacedName) (int, error) {
+	merges, err := listMerges(c)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	requested := time.Now().UTC().Format(time.RFC3339)
+	for i := range merges {
+		merge := &merges[i]
+		if merge.TargetKey() != key {
+			continue
+		}
+		original := merge.DeepCopy()
+		if merge.Annotations == nil {
+			merge.Annotations = map[string]string{}
+		}
+		merge.Annotations[v1alpha1.AnnotationRemerge] = requested
+		if err := c.Patch(context.TODO(), merge, client.MergeFrom(original)); err != nil {
+			return count, fmt.Errorf("requesting the merge of %s/%s again: %w", merge.Namespace, merge.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// targetRevisions gets the target with its revisions, the oldest first
+func targetRevisions(c client.Client, key types.NamespacedName) (*istio.VirtualService, []appsv1.ControllerRevision, error) {
+	target := &istio.VirtualService{}
+	if err := c.Get(context.TODO(), key, target); err != nil {
+		return nil, nil, err
+	}
+	list := &appsv1.ControllerRevisionList{}
+	if err := c.List(context.TODO(), list, client.InNamespace(key.Namespace),
+		client.MatchingLabels{v1alpha1.LabelRevisionTarget: key.Name}); err != nil {
+		return nil, nil, fmt.Errorf("listing the revisions of %s: %w", key, err)
+	}
+	return target, v1alpha1.TargetRevisions(list.Items, target), nil
+}
+
+func findRevision(revisions []appsv1.ControllerRevision, revision int64) (*v1alpha1.TargetRevision, error) {
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			return v1alpha1.DecodeTargetRevision(&revisions[i])
+		}
+	}
+	return nil, fmt.Errorf("no revision %d", revision)
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command kubectl-vsmerge is a kubectl plugin to inspect the
+// VirtualServiceMerges of a live cluster: kubectl vsmerge <command>
+package main
+
+import "github.com/monimesl/istio-virtualservice-merger/internal/cli"
+
+var commands = []cli.Command{
+	{Name: "targets", Usage: "list the targeted VirtualServices with their merge counts", Run: targets},
+	{Name: "explain", Usage: "show the routes of a VirtualService with the merges contributing them", Run: explain},
+	{Name: "diff", Usage: "show what applying or removing a VirtualServiceMerge changes on its target", Run: diff},
+	{Name: "status", Usage: "show the status conditions of the VirtualServiceMerges", Run: status},
+	{Name: "history", Usage: "list the revisions of a VirtualService written by the operator", Run: history},
+	{Name: "rollback", Usage: "restore and pin a VirtualService to a revision, or unpin it", Run: rollback},
+}
+
+func main() {
+	cli.Main("kubectl vsmerge", commands)
+}
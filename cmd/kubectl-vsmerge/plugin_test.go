@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var targetKey = types.NamespacedName{Namespace: "app-space", Name: "api-routes"}
+
+func newHttpRoute(name, prefix, host string) *v1alpha3.HTTPRoute {
+	return &v1alpha3.HTTPRoute{
+		Name: name,
+		Match: []*v1alpha3.HTTPMatchRequest{{
+			Uri: &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Prefix{Prefix: prefix}},
+		}},
+		Route: []*v1alpha3.HTTPRouteDestination{{Destination: &v1alpha3.Destination{Host: host}}},
+	}
+}
+
+func newMerge(name string, routes ...*v1alpha3.HTTPRoute) *v1alpha1.VirtualServiceMerge {
+	return &v1alpha1.VirtualServiceMerge{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: targetKey.Namespace, UID: types.UID(name + "-uid")},
+		Spec: v1alpha1.VirtualServiceMergeSpec{
+			Target: v1alpha1.Target{Name: targetKey.Name},
+			Patch:  v1alpha3.VirtualService{Http: routes},
+		},
+	}
+}
+
+// newCluster returns a client of a target merged by the review and rating merges, the review
+// merge having since changed its destination and added a route named after a rating route
+func newCluster() client.Client {
+	reviews := newMerge("review-routes", newHttpRoute("review-routes-0", "/reviews", "reviews"))
+	ratings := newMerge("rating-routes", newHttpRoute("", "/ratings", "ratings"))
+	target := &istio.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Name: targetKey.Name, Namespace: targetKey.Namespace},
+		Spec: v1alpha3.VirtualService{
+			Hosts: []string{"api.example.com"},
+			Http: []*v1alpha3.HTTPRoute{
+				newHttpRoute("review-routes-0", "/reviews", "reviews"),
+				newHttpRoute("rating-routes-0", "/ratings", "ratings"),
+				newHttpRoute("", "/", "default"),
+			},
+		},
+	}
+	sources := v1alpha1.TargetRouteSources(target)
+	sources.Set(reviews, []string{"http/review-routes-0"})
+	sources.Set(ratings, []string{"http/rating-routes-0"})
+	sources.Apply(target)
+	reviews.Spec.Patch.Http[0].Route[0].Destination.Host = "reviews-v2"
+	reviews.Spec.Patch.Http = append(reviews.Spec.Patch.Http, newHttpRoute("rating-routes-0", "/ratings/v2", "reviews-v2"))
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(target, reviews, ratings).Build()
+}
+
+func TestExplainTarget(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := explainTarget(out, newCluster(), targetKey); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := [][]string{
+		{"#", "KIND", "NAME", "MATCH", "PRECEDENCE", "MERGE"},
+		{"0", "http", "review-routes-0", "uri", "prefix", "/reviews", "0", "app-space/review-routes"},
+		{"1", "http", "rating-routes-0", "uri", "prefix", "/ratings", "0", "app-space/rating-routes"},
+		{"2", "http", "-", "uri", "prefix", "/", "0", "-"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("explain prints %d lines, expected %d:\n%s", len(lines), len(want), out)
+	}
+	for i, columns := range want {
+		if got := strings.Fields(lines[i]); len(got) < len(columns) || strings.Join(got[:len(columns)], " ") != strings.Join(columns, " ") {
+			t.Errorf("the line %d is %q, expected it to start with %q", i, lines[i], strings.Join(columns, " "))
+		}
+	}
+}
+
+func TestDiffMerge(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		merge     string
+		apply     []string
+		remove    []string
+		unchanged []string
+	}{
+		{
+			name:   "of a merge naming a route of another merge",
+			merge:  "review-routes",
+			apply:  []string{"forbidden: http/rating-routes-0 belongs to app-space/rating-routes"},
+			remove: []string{"-  name: review-routes-0"},
+			// the route of the rating merge stays when the review merge is removed
+			unchanged: []string{"-  name: rating-routes-0"},
+		},
+		{
+			name:      "of a merged merge",
+			merge:     "rating-routes",
+			apply:     []string{"no change"},
+			remove:    []string{"-  name: rating-routes-0"},
+			unchanged: []string{"-  name: review-routes-0"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			key := types.NamespacedName{Namespace: targetKey.Namespace, Name: tt.merge}
+			if err := diffMerge(out, newCluster(), key, false, 3); err != nil {
+				t.Fatal(err)
+			}
+			apply, remove, found := strings.Cut(out.String(), "\nRemoving ")
+			if !found {
+				t.Fatalf("the diff has no removal:\n%s", out)
+			}
+			for _, want := range tt.apply {
+				if !strings.Contains(apply, want) {
+					t.Errorf("applying lacks %q:\n%s", want, apply)
+				}
+			}
+			for _, want := range tt.remove {
+				if !strings.Contains(remove, want) {
+					t.Errorf("removing lacks %q:\n%s", want, remove)
+				}
+			}
+			for _, unwanted := range tt.unchanged {
+				if strings.Contains(remove, unwanted) {
+					t.Errorf("removing has %q:\n%s", unwanted, remove)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffMergeApply(t *testing.T) {
+	c := newCluster()
+	reviews := &v1alpha1.VirtualServiceMerge{}
+	key := types.NamespacedName{Namespace: targetKey.Namespace, Name: "review-routes"}
+	if err := c.Get(context.TODO(), key, reviews); err != nil {
+		t.Fatal(err)
+	}
+	reviews.Spec.Patch.Http = reviews.Spec.Patch.Http[:1]
+	if err := c.Update(context.TODO(), reviews); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := diffMerge(out, c, key, false, 3); err != nil {
+		t.Fatal(err)
+	}
+	apply, _, _ := strings.Cut(out.String(), "\nRemoving ")
+	for _, want := range []string{"-      host: reviews\n", "+      host: reviews-v2\n"} {
+		if !strings.Contains(apply, want) {
+			t.Errorf("applying lacks %q:\n%s", want, apply)
+		}
+	}
+}
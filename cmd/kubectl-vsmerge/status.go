@@ -0,0 +1,66 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+func status(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, true)
+	var failing bool
+	flags.BoolVar(&failing, "failing", false, "Only show the VirtualServiceMerges which are not applied")
+	cli.ParseInterspersed(flags, args)
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	merges, err := listMerges(c)
+	if err != nil {
+		return err
+	}
+	sort.Slice(merges, func(i, j int) bool {
+		if merges[i].Namespace != merges[j].Namespace {
+			return merges[i].Namespace < merges[j].Namespace
+		}
+		return merges[i].Name < merges[j].Name
+	})
+	table := newTable(os.Stdout, "NAMESPACE", "NAME", "TARGET", "APPLIED", "REASON", "MESSAGE")
+	for i := range merges {
+		merge := &merges[i]
+		if cluster.namespace != "" && merge.Namespace != cluster.namespace {
+			continue
+		}
+		applied, reason, message := "Unknown", "", ""
+		if cond := meta.FindStatusCondition(merge.Status.Conditions, v1alpha1.ConditionApplied); cond != nil {
+			applied, reason, message = string(cond.Status), cond.Reason, cond.Message
+		}
+		if failing && applied == "True" {
+			continue
+		}
+		printRow(table, merge.Namespace, merge.Name, merge.TargetKey(), applied, reason, message)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"sort"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func targets(args []string) error {
+	flags := flag.NewFlagSet("targets", flag.ExitOnError)
+	cluster := clusterFlags{}
+	cluster.register(flags, true)
+	cli.ParseInterspersed(flags, args)
+	c, err := cluster.client()
+	if err != nil {
+		return err
+	}
+	merges, err := listMerges(c)
+	if err != nil {
+		return err
+	}
+	type counts struct{ merges, applied int }
+	byTarget := map[types.NamespacedName]*counts{}
+	for i := range merges {
+		target := merges[i].TargetKey()
+		if cluster.namespace != "" && target.Namespace != cluster.namespace {
+			continue
+		}
+		if byTarget[target] == nil {
+			byTarget[target] = &counts{}
+		}
+		byTarget[target].merges++
+		if meta.IsStatusConditionTrue(merges[i].Status.Conditions, v1alpha1.ConditionApplied) {
+			byTarget[target].applied++
+		}
+	}
+	keys := make([]types.NamespacedName, 0, len(byTarget))
+	for key := range byTarget {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	table := newTable(os.Stdout, "NAMESPACE", "NAME", "MERGES", "APPLIED", "FOUND")
+	for _, key := range keys {
+		found := "yes"
+		if err := c.Get(context.TODO(), key, &istio.VirtualService{}); kerr.IsNotFound(err) {
+			found = "no"
+		} else if err != nil {
+			return err
+		}
+		printRow(table, key.Namespace, key.Name, byTarget[key].merges, byTarget[key].applied, found)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,30 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command vsmerge merges VirtualServiceMerge manifests into a VirtualService
+// manifest offline, using the same merge code as the operator.
+package main
+
+import "github.com/monimesl/istio-virtualservice-merger/internal/cli"
+
+var commands = []cli.Command{
+	{Name: "render", Usage: "print the target VirtualService with the merges applied", Run: render},
+	{Name: "split", Usage: "split a VirtualService into a base VirtualService and VirtualServiceMerges", Run: split},
+}
+
+func main() {
+	cli.Main("vsmerge", commands)
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func render(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	var target, namespace, output string
+	var deleted cli.StringsFlag
+	var verbose bool
+	flags.StringVar(&target, "target", "", "The file of the target VirtualService")
+	flags.StringVar(&namespace, "namespace", "default", "The namespace of the objects without one")
+	flags.StringVar(&output, "o", "yaml", "The output format: yaml or json")
+	flags.Var(&deleted, "delete", "Simulate the deletion of the VirtualServiceMerge with this [namespace/]name. Can be repeated")
+	flags.BoolVar(&verbose, "v", false, "Log the merge steps to stderr")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: vsmerge render -target <file> [flags] <merge file>...\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	files := cli.ParseInterspersed(flags, args)
+	if target == "" {
+		flags.Usage()
+		return errors.New("the -target flag is required")
+	}
+	log := logr.Discard()
+	if verbose {
+		log = zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stderr))
+	}
+
+	vs, err := cli.ReadVirtualService(target, namespace)
+	if err != nil {
+		return err
+	}
+	merges, err := cli.ReadMerges(files, namespace)
+	if err != nil {
+		return err
+	}
+	rendered, forbidden, err := renderTarget(log, vs, merges, deleted, namespace)
+	if err != nil {
+		return err
+	}
+	if err := cli.WriteObject(os.Stdout, rendered, output); err != nil {
+		return err
+	}
+	if len(forbidden) > 0 {
+		return fmt.Errorf("%w: %s", merge.ErrRouteConflict, strings.Join(forbidden, ", "))
+	}
+	return nil
+}
+
+// renderTarget merges the merges of the target into it one by one, in order, then removes the deleted
+// ones, the way the operator does. The merges naming the routes of earlier ones are forbidden and left
+// out, as the operator leaves them out; their names are returned.
+func renderTarget(log logr.Logger, vs *istio.VirtualService, merges []*v1alpha1.VirtualServiceMerge,
+	deleted []string, namespace string) (*istio.VirtualService, []string, error) {
+	targetKey := types.NamespacedName{Namespace: vs.Namespace, Name: vs.Name}
+	var applied []*v1alpha1.VirtualServiceMerge
+	var forbidden []string
+	for _, m := range merges {
+		key := m.Namespace + "/" + m.Name
+		if m.TargetKey() != targetKey {
+			fmt.Fprintf(os.Stderr, "skipping %s: it targets %s\n", key, m.TargetKey())
+			continue
+		}
+		merged, report, err := m.MergeInto(log, vs, nil, false)
+		if errors.Is(err, merge.ErrRouteConflict) {
+			printConflicts(key, report)
+			forbidden = append(forbidden, key)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		vs = merged
+		applied = append(applied, m)
+	}
+	for _, name := range deleted {
+		m := findMerge(applied, name, namespace)
+		if m == nil {
+			return nil, nil, fmt.Errorf("no applied VirtualServiceMerge %q to delete", name)
+		}
+		removed, _, err := m.MergeInto(log, vs, nil, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		vs = removed
+	}
+	return vs, forbidden, nil
+}
+
+// printConflicts reports the routes of other merges which the merge names
+func printConflicts(key string, report *merge.Report) {
+	for _, conflict := range report.Conflicts {
+		fmt.Fprintf(os.Stderr, "forbidden: %s names the route %s of %s\n", key, conflict.Route, conflict.Sources[0])
+	}
+}
+
+// findMerge finds the merge by its [namespace/]name
+func findMerge(merges []*v1alpha1.VirtualServiceMerge, name, namespace string) *v1alpha1.VirtualServiceMerge {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if ns, n, ok := strings.Cut(name, "/"); ok {
+		key = types.NamespacedName{Namespace: ns, Name: n}
+	}
+	for _, merge := range merges {
+		if merge.Namespace == key.Namespace && merge.Name == key.Name {
+			return merge
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+)
+
+const testData = "../../tests/data/"
+
+func readTestData(t *testing.T) (*istio.VirtualService, []*v1alpha1.VirtualServiceMerge) {
+	vs, err := cli.ReadVirtualService(testData+"vs.yaml", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	merges, err := cli.ReadMerges([]string{testData + "vs-merge-1.yaml", testData + "vs-merge-2.yaml"}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vs, merges
+}
+
+func httpRouteNames(vs *istio.VirtualService) string {
+	names := make([]string, len(vs.Spec.Http))
+	for i, route := range vs.Spec.Http {
+		names[i] = route.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRenderTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		delete []string
+		routes string
+		output string
+		want   []string
+	}{
+		{
+			name:   "as yaml",
+			routes: "product-routes-0,review-routes-0,",
+			output: "yaml",
+			want:   []string{"name: product-routes-0", "name: review-routes-0", "istiomerger.monime.sl/merge-count: \"2\""},
+		},
+		{
+			name:   "as json",
+			routes: "product-routes-0,review-routes-0,",
+			output: "json",
+			want:   []string{`"name": "product-routes-0"`, `"name": "review-routes-0"`},
+		},
+		{
+			name:   "without a deleted merge",
+			delete: []string{"default/product-routes"},
+			routes: "review-routes-0,",
+			output: "yaml",
+			want:   []string{"name: review-routes-0", "istiomerger.monime.sl/merge-count: \"1\""},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vs, merges := readTestData(t)
+			rendered, forbidden, err := renderTarget(logr.Discard(), vs, merges, tt.delete, "default")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(forbidden) > 0 {
+				t.Errorf("the merges %v are forbidden", forbidden)
+			}
+			if got := httpRouteNames(rendered); got != tt.routes {
+				t.Errorf("the target has the routes %s, expected %s", got, tt.routes)
+			}
+			out := &bytes.Buffer{}
+			if err := cli.WriteObject(out, rendered, tt.output); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("the output lacks %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderTargetConflict(t *testing.T) {
+	vs, merges := readTestData(t)
+	// a merge of another namespace naming its route after the route of review-routes
+	taker := merges[0].DeepCopy()
+	taker.Namespace = "team-b"
+	taker.Spec.Target.Namespace = "default"
+	taker.Spec.Patch.Http[0].Route[0].Destination.Host = "other-service"
+
+	rendered, forbidden, err := renderTarget(logr.Discard(), vs, append(merges, taker), nil, "default")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(forbidden, ","); got != "team-b/review-routes" {
+		t.Errorf("the forbidden merges are %s, expected team-b/review-routes", got)
+	}
+	if host := rendered.Spec.Http[1].Route[0].Destination.Host; host != "review-service" {
+		t.Errorf("the route review-routes-0 goes to %s, expected review-service", host)
+	}
+}
+
+func TestRenderTargetUnknownDeletion(t *testing.T) {
+	vs, merges := readTestData(t)
+	if _, _, err := renderTarget(logr.Discard(), vs, merges, []string{"rating-routes"}, "default"); err == nil {
+		t.Error("deleting an unknown merge succeeds")
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// routeGroup is a set of http routes moved into a VirtualServiceMerge
+type routeGroup struct {
+	name      string
+	namespace string
+	routes    []*v1alpha3.HTTPRoute
+}
+
+// grouper returns the group name and namespace of a route or false to keep it in the base
+type grouper func(route *v1alpha3.HTTPRoute) (string, string, bool)
+
+// clusterHosts tells the in-cluster service hosts from the external ones
+type clusterHosts struct {
+	// domain is the cluster domain ending the fully qualified service hosts
+	domain string
+	// namespaces are the namespaces which the <service>.<namespace> short hosts may name
+	namespaces map[string]bool
+}
+
+func split(args []string) error {
+	flags := flag.NewFlagSet("split", flag.ExitOnError)
+	var source, namespace, by, pattern, namespaces string
+	hosts := clusterHosts{namespaces: map[string]bool{}}
+	flags.StringVar(&source, "f", "", "The file of the VirtualService to split")
+	flags.StringVar(&namespace, "namespace", "default", "The namespace of the VirtualService if it has none")
+	flags.StringVar(&by, "by", "", "How to group the http routes: host-namespace, uri-prefix or name-pattern")
+	flags.StringVar(&pattern, "pattern", "", "With -by name-pattern, the regular expression whose first group names the route group")
+	flags.StringVar(&hosts.domain, "cluster-domain", "cluster.local", "With -by host-namespace, the domain of the cluster service hosts")
+	flags.StringVar(&namespaces, "cluster-namespaces", "", "With -by host-namespace, the comma separated namespaces which the <service>.<namespace> hosts may name")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: vsmerge split -f <file> -by <rule> [flags]\n\n"+
+			"Prints a base VirtualService and one VirtualServiceMerge per route group. The http routes are renamed with\n"+
+			"decreasing precedences so that merging them back reproduces the original route order. The tcp and tls\n"+
+			"routes stay in the base VirtualService.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if source == "" {
+		flags.Usage()
+		return errors.New("the -f flag is required")
+	}
+	vs, err := cli.ReadVirtualService(source, namespace)
+	if err != nil {
+		return err
+	}
+	for _, ns := range strings.Split(namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			hosts.namespaces[ns] = true
+		}
+	}
+	group, err := newGrouper(by, pattern, vs.Namespace, hosts)
+	if err != nil {
+		return err
+	}
+
+	expected := renameRoutes(vs)
+	base, merges := splitRoutes(expected, group)
+	if err := verifySplit(expected, base, merges); err != nil {
+		return err
+	}
+	objects := []interface{}{base}
+	for _, merge := range merges {
+		objects = append(objects, merge)
+	}
+	for _, obj := range objects {
+		data, err := diff.Marshal(obj, "yaml")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---\n%s", data)
+	}
+	return nil
+}
+
+func newGrouper(by, pattern, namespace string, hosts clusterHosts) (grouper, error) {
+	switch by {
+	case "host-namespace":
+		return func(route *v1alpha3.HTTPRoute) (string, string, bool) {
+			if len(route.Route) == 0 {
+				return "", "", false
+			}
+			ns := hosts.namespace(route.Route[0].GetDestination().GetHost(), namespace)
+			return ns, ns, true
+		}, nil
+	case "uri-prefix":
+		return func(route *v1alpha3.HTTPRoute) (string, string, bool) {
+			for _, match := range route.Match {
+				uri := match.GetUri().GetPrefix() + match.GetUri().GetExact()
+				if segment := strings.Split(strings.TrimPrefix(uri, "/"), "/")[0]; segment != "" {
+					return segment, namespace, true
+				}
+			}
+			return "", "", false
+		}, nil
+	case "name-pattern":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if re.NumSubexp() < 1 {
+			return nil, errors.New("the -pattern must have a group naming the route group")
+		}
+		return func(route *v1alpha3.HTTPRoute) (string, string, bool) {
+			if m := re.FindStringSubmatch(route.Name); m != nil && m[1] != "" {
+				return m[1], namespace, true
+			}
+			return "", "", false
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown -by rule %q", by)
+}
+
+// namespace returns the namespace of an in-cluster service host: <service>.<namespace>.svc, optionally
+// followed by the cluster domain, or <service>.<namespace> naming a known namespace. The short names
+// and the external hosts, such as httpbin.org, are kept in the namespace of the VirtualService.
+func (h clusterHosts) namespace(host, namespace string) string {
+	parts := strings.Split(host, ".")
+	switch {
+	case len(parts) == 2 && h.namespaces[parts[1]]:
+		return parts[1]
+	case len(parts) >= 3 && parts[2] == "svc" && (len(parts) == 3 || strings.Join(parts[3:], ".") == h.domain):
+		return parts[1]
+	}
+	return namespace
+}
+
+// renameRoutes returns a copy of the VirtualService whose http routes are named with
+// strictly decreasing precedences, which the merge sorts the routes by
+func renameRoutes(vs *istio.VirtualService) *istio.VirtualService {
+	out := &istio.VirtualService{
+		TypeMeta: vs.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        vs.Name,
+			Namespace:   vs.Namespace,
+			Labels:      vs.Labels,
+			Annotations: vs.Annotations,
+		},
+	}
+	vs.Spec.DeepCopyInto(&out.Spec)
+	count := len(out.Spec.Http)
+	for i, route := range out.Spec.Http {
+		name := route.Name
+		if name == "" {
+			name = vs.Name
+		}
+		route.Name = fmt.Sprintf("%s-%d", name, count-i-1)
+	}
+	return out
+}
+
+func splitRoutes(vs *istio.VirtualService, group grouper) (*istio.VirtualService, []*v1alpha1.VirtualServiceMerge) {
+	base := vs.DeepCopy()
+	base.Spec.Http = nil
+	groups := map[string]*routeGroup{}
+	for _, route := range vs.Spec.Http {
+		name, namespace, ok := group(route)
+		if !ok {
+			base.Spec.Http = append(base.Spec.Http, route)
+			continue
+		}
+		key := namespace + "/" + name
+		if groups[key] == nil {
+			groups[key] = &routeGroup{name: name, namespace: namespace}
+		}
+		groups[key].routes = append(groups[key].routes, route)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// distinct groups may sanitize to the same name, the later ones in key order get a numbered suffix
+	taken := map[string]bool{}
+	for _, key := range keys {
+		g, group := groups[key], groups[key].name
+		g.name = mergeName(vs.Name, group, "")
+		for i := 2; taken[g.namespace+"/"+g.name]; i++ {
+			g.name = mergeName(vs.Name, group, fmt.Sprintf("-%d", i))
+		}
+		taken[g.namespace+"/"+g.name] = true
+	}
+	merges := make([]*v1alpha1.VirtualServiceMerge, 0, len(groups))
+	var allowed []string
+	for _, key := range keys {
+		g := groups[key]
+		merge := &v1alpha1.VirtualServiceMerge{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha1.GroupVersion.String(),
+				Kind:       cli.KindVirtualServiceMerge,
+			},
+			ObjectMeta: metav1.ObjectMeta{Name: g.name, Namespace: g.namespace},
+			Spec: v1alpha1.VirtualServiceMergeSpec{
+				Target: v1alpha1.Target{Name: vs.Name},
+				Patch:  v1alpha3.VirtualService{Http: g.routes},
+			},
+		}
+		if g.namespace != vs.Namespace {
+			merge.Spec.Target.Namespace = vs.Namespace
+			if len(allowed) == 0 || allowed[len(allowed)-1] != g.namespace {
+				allowed = append(allowed, g.namespace)
+			}
+		}
+		merges = append(merges, merge)
+	}
+	if len(allowed) > 0 {
+		// the base accepts the merges of the other namespaces without a MergePolicy
+		if base.Annotations == nil {
+			base.Annotations = map[string]string{}
+		}
+		base.Annotations[v1alpha1.AnnotationAllowedNamespaces] = strings.Join(allowed, ",")
+	}
+	return base, merges
+}
+
+// mergeName builds a valid object name for the merge of the group, ending with the suffix
+func mergeName(vsName, group, suffix string) string {
+	name := strings.Trim(regexp.MustCompile("[^a-z0-9-]+").
+		ReplaceAllString(strings.ToLower(vsName+"-"+group), "-"), "-")
+	if max := validation.DNS1123SubdomainMaxLength - len(suffix); len(name) > max {
+		name = strings.TrimRight(name[:max], "-")
+	}
+	return name + suffix
+}
+
+// verifySplit merges the VirtualServiceMerges into the base in both orders
+// and checks that the results are identical to the expected VirtualService
+func verifySplit(expected, base *istio.VirtualService, merges []*v1alpha1.VirtualServiceMerge) error {
+	names := map[string]bool{}
+	for _, m := range merges {
+		key := m.Namespace + "/" + m.Name
+		if names[key] {
+			return fmt.Errorf("the split names several VirtualServiceMerges %s", key)
+		}
+		names[key] = true
+	}
+	want, err := diff.Marshal(&expected.Spec, "yaml")
+	if err != nil {
+		return err
+	}
+	for _, reverse := range []bool{false, true} {
+		patches := make([]merge.Patch, len(merges))
+		for i := range merges {
+			m := merges[i]
+			if reverse {
+				m = merges[len(merges)-1-i]
+			}
+			patches[i] = m.MergePatch()
+		}
+		merged, _, err := merge.Merge(&base.Spec, patches, merge.Options{})
+		if err != nil {
+			return err
+		}
+		got, err := diff.Marshal(merged, "yaml")
+		if err != nil {
+			return err
+		}
+		if d := diff.Lines(string(want), string(got), 3); d != "" {
+			return fmt.Errorf("the split does not reproduce the VirtualService:\n%s", d)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "verified: merging the %d VirtualServiceMerges into the base reproduces the VirtualService\n", len(merges))
+	return nil
+}
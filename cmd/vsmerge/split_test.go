@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHostNamespace(t *testing.T) {
+	hosts := clusterHosts{domain: "cluster.local", namespaces: map[string]bool{"team-a": true}}
+	for _, tt := range []struct {
+		host, namespace string
+	}{
+		{"reviews.team-b.svc.cluster.local", "team-b"},
+		{"reviews.team-b.svc", "team-b"},
+		{"reviews.team-b.svc.other.domain", "gateway"},
+		{"reviews.team-a", "team-a"},
+		{"reviews.team-b", "gateway"},
+		{"httpbin.org", "gateway"},
+		{"api.stripe.com", "gateway"},
+		{"reviews", "gateway"},
+	} {
+		if got := hosts.namespace(tt.host, "gateway"); got != tt.namespace {
+			t.Errorf("the host %s is in the namespace %s, expected %s", tt.host, got, tt.namespace)
+		}
+	}
+}
+
+func newSplitVirtualService(routes ...*v1alpha3.HTTPRoute) *istio.VirtualService {
+	return renameRoutes(&istio.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-routes", Namespace: "gateway"},
+		Spec:       v1alpha3.VirtualService{Hosts: []string{"api.example.com"}, Http: routes},
+	})
+}
+
+func newSplitRoute(name, host string) *v1alpha3.HTTPRoute {
+	route := &v1alpha3.HTTPRoute{Name: name}
+	if host != "" {
+		route.Route = []*v1alpha3.HTTPRouteDestination{{Destination: &v1alpha3.Destination{Host: host}}}
+	}
+	return route
+}
+
+func mergeKeys(merges []*v1alpha1.VirtualServiceMerge) string {
+	keys := make([]string, len(merges))
+	for i, m := range merges {
+		keys[i] = m.Namespace + "/" + m.Name
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestSplitRoutes(t *testing.T) {
+	byHost, err := newGrouper("host-namespace", "", "gateway", clusterHosts{domain: "cluster.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName, err := newGrouper("name-pattern", "^([^-]+)", "gateway", clusterHosts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		vs      *istio.VirtualService
+		group   grouper
+		base    int
+		merges  string
+		allowed string
+	}{
+		{
+			name: "by host namespace",
+			vs: newSplitVirtualService(
+				newSplitRoute("reviews", "reviews.team-a.svc.cluster.local"),
+				newSplitRoute("httpbin", "httpbin.org"),
+				newSplitRoute("redirect", ""),
+				newSplitRoute("ratings", "ratings.team-a.svc"),
+			),
+			group:   byHost,
+			base:    1,
+			merges:  "gateway/api-routes-gateway,team-a/api-routes-team-a",
+			allowed: "team-a",
+		},
+		{
+			name: "with colliding sanitized names",
+			vs: newSplitVirtualService(
+				newSplitRoute("Reviews", "reviews"),
+				newSplitRoute("reviews!", "reviews"),
+				newSplitRoute("reviews_", "reviews"),
+			),
+			group:  byName,
+			merges: "gateway/api-routes-reviews,gateway/api-routes-reviews-2,gateway/api-routes-reviews-3",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			base, merges := splitRoutes(tt.vs, tt.group)
+			if len(base.Spec.Http) != tt.base {
+				t.Errorf("the base keeps %d routes, expected %d", len(base.Spec.Http), tt.base)
+			}
+			if got := mergeKeys(merges); got != tt.merges {
+				t.Errorf("the split makes the merges %s, expected %s", got, tt.merges)
+			}
+			if got := base.Annotations[v1alpha1.AnnotationAllowedNamespaces]; got != tt.allowed {
+				t.Errorf("the base allows the namespaces %q, expected %q", got, tt.allowed)
+			}
+			if err := verifySplit(tt.vs, base, merges); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestVerifySplit(t *testing.T) {
+	group, err := newGrouper("uri-prefix", "", "gateway", clusterHosts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newVirtualService := func() *istio.VirtualService {
+		vs := newSplitVirtualService(newSplitRoute("reviews", "reviews"), newSplitRoute("ratings", "ratings"))
+		for i, prefix := range []string{"/reviews", "/ratings"} {
+			vs.Spec.Http[i].Match = []*v1alpha3.HTTPMatchRequest{{
+				Uri: &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Prefix{Prefix: prefix}},
+			}}
+		}
+		return vs
+	}
+	for _, tt := range []struct {
+		name   string
+		change func(merges []*v1alpha1.VirtualServiceMerge)
+		err    string
+	}{
+		{
+			name:   "of the split",
+			change: func([]*v1alpha1.VirtualServiceMerge) {},
+		},
+		{
+			name: "of merges named alike",
+			change: func(merges []*v1alpha1.VirtualServiceMerge) {
+				merges[1].Name = merges[0].Name
+			},
+			err: "the split names several VirtualServiceMerges gateway/api-routes-ratings",
+		},
+		{
+			name: "of a merge missing a route",
+			change: func(merges []*v1alpha1.VirtualServiceMerge) {
+				merges[0].Spec.Patch.Http = nil
+			},
+			err: "the split does not reproduce the VirtualService",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			vs := newVirtualService()
+			base, merges := splitRoutes(vs, group)
+			tt.change(merges)
+			err := verifySplit(vs, base, merges)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Error(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("the verification fails with %v, expected %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -18,16 +18,21 @@ package controllers
 
 import (
 	"context"
+	"reflect"
+	"time"
 
 	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
 	"github.com/monimesl/operator-helper/reconciler"
 	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
 	versionedclient "istio.io/client-go/pkg/clientset/versioned"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -39,6 +44,9 @@ type VirtualServicePatchReconciler struct {
 	reconciler.Context
 	IstioClient    *versionedclient.Clientset
 	OldObjectCache cache.Indexer
+	Options        Options
+	targets        targetLocks
+	debouncer      targetDebouncer
 }
 
 func (r *VirtualServicePatchReconciler) Configure(ctx reconciler.Context) error {
@@ -50,6 +58,10 @@ func (r *VirtualServicePatchReconciler) Configure(ctx reconciler.Context) error
 					return true
 				},
 				UpdateFunc: func(e event.UpdateEvent) bool {
+					if !mergeChanged(e) {
+						// the status written by the reconcile does not trigger another one
+						return false
+					}
 					_ = r.OldObjectCache.Add(e.ObjectOld)
 					return true
 				},
@@ -62,53 +74,95 @@ func (r *VirtualServicePatchReconciler) Configure(ctx reconciler.Context) error
 			},
 		)).
 		Watches(&source.Kind{Type: &istio.VirtualService{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
-			vs := obj.(*istio.VirtualService)
-			requests := make([]reconcile.Request, 0)
-
-			// skip if vs is being deleted
-			if !vs.GetDeletionTimestamp().IsZero() {
-				return requests
-			}
-			// get all virtual service merge whose target is this virtual service
-			vsmegeList := &v1alpha1.VirtualServiceMergeList{}
-			if err := r.Client().List(context.TODO(), vsmegeList, &client.ListOptions{
-				Namespace: vs.GetNamespace(),
-			}); err != nil {
-				panic(err)
-			}
-			for _, vsmerge := range vsmegeList.Items {
-				targetNamespace := vsmerge.Spec.Target.Namespace
-				if targetNamespace == "" {
-					targetNamespace = vsmerge.GetNamespace()
-				}
-				// only look for vs that is a target for any of the merge
-				if vsmerge.Spec.Target.Name == vs.GetName() && targetNamespace == vs.GetNamespace() {
-					request := reconcile.Request{
-						NamespacedName: types.NamespacedName{
-							Namespace: vsmerge.GetNamespace(),
-							Name:      vsmerge.GetName(),
-						},
-					}
-					requests = append(requests, request)
-				}
+			// deleted targets are enqueued too so that their merges report the target missing
+			requests := r.mergesTargeting(types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()})
+			if owner := metav1.GetControllerOf(obj); owner != nil && owner.Kind == "VirtualServiceMerge" {
+				// a delegate edited by hand is repaired by its merge
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: owner.Name}})
 			}
 			return requests
 		})).
+		Watches(&source.Kind{Type: &v1alpha1.MergePolicy{}}, handler.EnqueueRequestsFromMapFunc(r.mergesGovernedBy)).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles,
+			RateLimiter:             r.Options.RateLimiter,
+		}).
 		Complete(r)
 }
 
-func (r *VirtualServicePatchReconciler) Reconcile(_ context.Context, request reconcile.Request) (reconcile.Result, error) {
+// mergeChanged reports if the update of a merge is a resync or changes more than its status:
+// its spec, its labels and annotations holding its shard and remerge request, its finalizers or its deletion
+func mergeChanged(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return true
+	}
+	return e.ObjectOld.GetResourceVersion() == e.ObjectNew.GetResourceVersion() ||
+		predicate.GenerationChangedPredicate{}.Update(e) ||
+		predicate.LabelChangedPredicate{}.Update(e) ||
+		predicate.AnnotationChangedPredicate{}.Update(e) ||
+		!e.ObjectOld.GetDeletionTimestamp().Equal(e.ObjectNew.GetDeletionTimestamp()) ||
+		!reflect.DeepEqual(e.ObjectOld.GetFinalizers(), e.ObjectNew.GetFinalizers())
+}
+
+// mergesGovernedBy maps a MergePolicy to the merges whose target it governs
+func (r *VirtualServicePatchReconciler) mergesGovernedBy(obj client.Object) []reconcile.Request {
+	policy := obj.(*v1alpha1.MergePolicy)
+	return r.mergesTargeting(types.NamespacedName{
+		Namespace: policy.Spec.Target.Namespace,
+		Name:      policy.Spec.Target.Name,
+	})
+}
+
+func (r *VirtualServicePatchReconciler) Reconcile(parent context.Context, request reconcile.Request) (reconcile.Result, error) {
 	patch := &v1alpha1.VirtualServiceMerge{}
 	oldObj, exists, err := r.OldObjectCache.GetByKey(request.NamespacedName.String())
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	inScope := false
+	var heldBack time.Duration
+	ctx, span := correlate(r.Context, parent, request.NamespacedName, r.Options.TracerProvider)
 	result, err := r.Run(request, patch, func(_ bool) error {
+		allowed, err := r.Options.Scope.allowsMerge(r.Client(), patch)
+		if err != nil {
+			return err
+		}
+		if inScope = allowed; !inScope {
+			ctx.Logger().Info("Patch outside the scope of this operator. Skipping.", logging.KeyAction, logging.ActionSkip)
+			return nil
+		}
+		// a target has a single writer at a time
+		targets := []types.NamespacedName{patch.TargetKey()}
+		var oldPatch *v1alpha1.VirtualServiceMerge
 		if exists {
-			if err := Reconcile(r.Context, r.IstioClient, patch, oldObj); err != nil {
+			oldPatch = oldObj.(*v1alpha1.VirtualServiceMerge)
+			targets = append(targets, oldPatch.TargetKey())
+		}
+		unlock := r.targets.lock(ctx.Logger(), targets...)
+		defer unlock()
+		if r.holdsBack(patch, oldPatch) {
+			if r.debouncer.batched(request.NamespacedName, patch.Generation) {
+				ctx.Logger().Info("Patch already merged into the target along with other ones. Skipping.",
+					logging.KeyAction, logging.ActionSkip)
+			} else if wait, batch := r.debouncer.hold(patch.TargetKey(), request.NamespacedName,
+				patch.Generation, time.Now(), r.Options.Debounce); wait > 0 {
+				ctx.Logger().V(1).Info("Holding back the change of the patch", "wait", wait.String())
+				heldBack = wait
+				return nil
+			} else if err := r.writeBatch(ctx, r.IstioClient, patch, batch); err != nil {
+				return err
+			}
+			if exists {
+				_ = r.OldObjectCache.Delete(oldObj)
+			}
+			return nil
+		}
+		if exists {
+			if err := Reconcile(ctx, r.IstioClient, patch, oldObj, r.Options); err != nil {
 				if kerr.IsNotFound(err) {
 					// do not need to panic just log output
-					r.Context.Logger().Info("Virtual service not found. Nothing to sync.")
+					ctx.Logger().Info("Virtual service not found. Nothing to sync.")
 					// update completed, remove key from cache
 					_ = r.OldObjectCache.Delete(oldObj)
 					return nil
@@ -118,10 +172,10 @@ func (r *VirtualServicePatchReconciler) Reconcile(_ context.Context, request rec
 			// update completed, remove key from cache
 			_ = r.OldObjectCache.Delete(oldObj)
 		} else {
-			if err := Reconcile(r.Context, r.IstioClient, patch, nil); err != nil {
+			if err := Reconcile(ctx, r.IstioClient, patch, nil, r.Options); err != nil {
 				if kerr.IsNotFound(err) {
 					// do not need to panic just log output
-					r.Context.Logger().Info("Virtual service not found. Nothing to sync.")
+					ctx.Logger().Info("Virtual service not found. Nothing to sync.")
 					return nil
 				}
 				return err
@@ -129,5 +183,13 @@ func (r *VirtualServicePatchReconciler) Reconcile(_ context.Context, request rec
 		}
 		return nil
 	})
+	endSpan(span, err)
+	if err == nil && heldBack > 0 {
+		// the change is written once the debounce window of the target closes
+		result.RequeueAfter = heldBack
+	} else if err == nil && inScope && r.Options.ResyncPeriod > 0 && patch.DeletionTimestamp.IsZero() {
+		// compare the patch with its target again later to repair manual edits
+		result.RequeueAfter = r.Options.ResyncPeriod
+	}
 	return result, err
 }
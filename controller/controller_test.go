@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/tests/mocks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("VirtualServicePatchReconciler", func() {
+	newMerge := func() *v1alpha1.VirtualServiceMerge {
+		return &v1alpha1.VirtualServiceMerge{
+			ObjectMeta: v1.ObjectMeta{
+				Name: "review-routes", Namespace: "app-space", Generation: 2, ResourceVersion: "7",
+				Finalizers: []string{finalizerName},
+			},
+			Spec: v1alpha1.VirtualServiceMergeSpec{Target: v1alpha1.Target{Name: "api-routes"}},
+			Status: v1alpha1.VirtualServicePatchStatus{
+				ObservedGeneration: 2,
+				ReconcileID:        "previous",
+				Conditions: []v1.Condition{{
+					Type:               v1alpha1.ConditionApplied,
+					Status:             v1.ConditionTrue,
+					Reason:             v1alpha1.ReasonApplied,
+					Message:            "The patch is merged into the target",
+					ObservedGeneration: 2,
+					LastTransitionTime: v1.Now(),
+				}},
+			},
+		}
+	}
+
+	Context("method mergeChanged(event)", func() {
+		DescribeTable("filters the updates of the status only",
+			func(change func(merge *v1alpha1.VirtualServiceMerge), changed bool) {
+				old := newMerge()
+				updated := old.DeepCopy()
+				updated.ResourceVersion = "8"
+				change(updated)
+				Expect(mergeChanged(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(Equal(changed))
+			},
+			Entry("of the status", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.Status.ReconcileID = "next"
+			}, false),
+			Entry("of the resync", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.ResourceVersion = "7"
+			}, true),
+			Entry("of the spec", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.Generation = 3
+			}, true),
+			Entry("of the shard", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.Labels = map[string]string{ShardLabel: "one"}
+			}, true),
+			Entry("of the remerge request", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.Annotations = map[string]string{v1alpha1.AnnotationRemerge: "2026-10-19T08:00:00Z"}
+			}, true),
+			Entry("of the finalizers", func(merge *v1alpha1.VirtualServiceMerge) {
+				merge.Finalizers = nil
+			}, true),
+			Entry("of the deletion", func(merge *v1alpha1.VirtualServiceMerge) {
+				now := v1.Now()
+				merge.DeletionTimestamp = &now
+			}, true),
+		)
+	})
+
+	Context("method updateStatus(ctx, patch)", func() {
+		It("records the reconcile ID only when the status changes", func() {
+			merge := newMerge()
+			scheme := runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(merge).Build()
+			mockCtx := mocks.NewMockContext(gomock.NewController(GinkgoT()))
+			mockCtx.EXPECT().Client().Return(c).AnyTimes()
+			mockCtx.EXPECT().Logger().Return(logr.Discard()).AnyTimes()
+			ctx, _ := correlate(mockCtx, context.Background(),
+				types.NamespacedName{Namespace: merge.Namespace, Name: merge.Name}, nil)
+			Expect(c.Get(context.TODO(), types.NamespacedName{Namespace: merge.Namespace, Name: merge.Name}, merge)).To(Succeed())
+			ctx = withObserved(ctx, merge.Status)
+
+			Expect(updateStatus(ctx, merge)).To(Succeed())
+			Expect(merge.Status.ReconcileID).To(Equal("previous"))
+
+			meta.SetStatusCondition(&merge.Status.Conditions, v1.Condition{
+				Type:    v1alpha1.ConditionApplied,
+				Status:  v1.ConditionFalse,
+				Reason:  v1alpha1.ReasonTargetMissing,
+				Message: "The target app-space/api-routes does not exist",
+			})
+			Expect(updateStatus(ctx, merge)).To(Succeed())
+			Expect(merge.Status.ReconcileID).To(Equal(reconcileID(ctx)))
+		})
+	})
+})
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/reconciler"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotationReconcileID is the annotation of the events holding the ID of the reconcile which recorded them
+const AnnotationReconcileID = "istiomerger.monime.sl/reconcile-id"
+
+// correlatedContext is the context of a single reconcile, whose logger carries the reconcile
+// ID and the identity of the merge and its target, and whose context its current span
+type correlatedContext struct {
+	reconciler.Context
+	log     logr.Logger
+	id      string
+	context context.Context
+	tracer  trace.Tracer
+	// client replaces the client of the reconciler when set
+	client client.Client
+	// observed is the status of the merge as read before the reconcile changed it
+	observed *v1alpha1.VirtualServicePatchStatus
+}
+
+func (c *correlatedContext) Logger() logr.Logger {
+	return c.log
+}
+
+func (c *correlatedContext) Client() client.Client {
+	if c.client != nil {
+		return c.client
+	}
+	return c.Context.Client()
+}
+
+// correlate returns the context of a new reconcile of the merge, traced by the
+// provider, or the global one when nil, as a child of the span of the parent
+func correlate(ctx reconciler.Context, parent context.Context, merge types.NamespacedName,
+	provider trace.TracerProvider) (reconciler.Context, trace.Span) {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	id := string(uuid.NewUUID())
+	tracer := provider.Tracer(tracerName)
+	spanCtx, span := tracer.Start(parent, "VirtualServiceMerge.Reconcile", trace.WithAttributes(
+		attribute.String(logging.KeyMerge, merge.String()),
+		attribute.String(logging.KeyReconcileID, id),
+	))
+	return &correlatedContext{
+		Context: ctx,
+		log:     ctx.Logger().WithValues(logging.KeyReconcileID, id, logging.KeyMerge, merge.String()),
+		id:      id,
+		context: spanCtx,
+		tracer:  tracer,
+	}, span
+}
+
+// asCorrelated returns the context of the reconcile, untraced and without ID outside of one
+func asCorrelated(ctx reconciler.Context) *correlatedContext {
+	if correlated, ok := ctx.(*correlatedContext); ok {
+		return correlated
+	}
+	return &correlatedContext{
+		Context: ctx,
+		log:     ctx.Logger(),
+		context: context.TODO(),
+		tracer:  trace.NewNoopTracerProvider().Tracer(tracerName),
+	}
+}
+
+// withValues returns the context whose logger adds the key/value pairs to its lines
+func withValues(ctx reconciler.Context, keysAndValues ...interface{}) reconciler.Context {
+	correlated := *asCorrelated(ctx)
+	correlated.log = correlated.log.WithValues(keysAndValues...)
+	return &correlated
+}
+
+// withClient returns the context whose requests go through the client
+func withClient(ctx reconciler.Context, c client.Client) reconciler.Context {
+	correlated := *asCorrelated(ctx)
+	correlated.client = c
+	return &correlated
+}
+
+// withObserved returns the context remembering the status of the merge as read
+func withObserved(ctx reconciler.Context, status v1alpha1.VirtualServicePatchStatus) reconciler.Context {
+	correlated := *asCorrelated(ctx)
+	correlated.observed = status.DeepCopy()
+	return &correlated
+}
+
+// reconcileID returns the ID of the reconcile of the context, empty outside of one
+func reconcileID(ctx reconciler.Context) string {
+	return asCorrelated(ctx).id
+}
+
+// requestContext returns the context of the requests of the reconcile, holding its current span
+func requestContext(ctx reconciler.Context) context.Context {
+	return asCorrelated(ctx).context
+}
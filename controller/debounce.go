@@ -0,0 +1,295 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/oputil"
+	"github.com/monimesl/operator-helper/reconciler"
+	"go.opentelemetry.io/otel/attribute"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	networkingv1alpha3 "istio.io/client-go/pkg/clientset/versioned/typed/networking/v1alpha3"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Debounce holds back the changes of the merges of a target until none arrived for
+// Window, or MaxDelay after the first one, to write all of them into the target at once
+type Debounce struct {
+	Window   time.Duration
+	MaxDelay time.Duration
+}
+
+// targetBatch is the held back changes of the merges of a target
+type targetBatch struct {
+	first, last time.Time
+	// merges are the held back merges and their generation
+	merges map[types.NamespacedName]int64
+}
+
+// targetDebouncer holds back the changes of the merges per target
+type targetDebouncer struct {
+	mu      sync.Mutex
+	batches map[types.NamespacedName]*targetBatch
+	// written are the merges written along with another one, and their generation
+	written map[types.NamespacedName]int64
+}
+
+// hold records the change of the merge and returns how long it is still held back,
+// or the merges of the batch of the target once it is due
+func (d *targetDebouncer) hold(target, merge types.NamespacedName, generation int64, now time.Time, opts Debounce) (time.Duration, []types.NamespacedName) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.batches == nil {
+		d.batches = map[types.NamespacedName]*targetBatch{}
+	}
+	batch, found := d.batches[target]
+	if !found {
+		batch = &targetBatch{first: now, merges: map[types.NamespacedName]int64{}}
+		d.batches[target] = batch
+	}
+	if held, found := batch.merges[merge]; !found || held != generation {
+		if len(batch.merges) > 0 {
+			coalescedEvents.WithLabelValues(target.Namespace, target.Name).Inc()
+		}
+		batch.merges[merge] = generation
+		batch.last = now
+	}
+	due := batch.last.Add(opts.Window)
+	if limit := batch.first.Add(opts.MaxDelay); limit.Before(due) {
+		due = limit
+	}
+	if wait := due.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	delete(d.batches, target)
+	merges := make([]types.NamespacedName, 0, len(batch.merges))
+	for held := range batch.merges {
+		merges = append(merges, held)
+	}
+	sort.Slice(merges, func(i, j int) bool {
+		return merges[i].String() < merges[j].String()
+	})
+	return 0, merges
+}
+
+// batched checks if the merge was written at this generation along with another one, and forgets it
+func (d *targetDebouncer) batched(merge types.NamespacedName, generation int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	written, found := d.written[merge]
+	delete(d.written, merge)
+	return found && written == generation
+}
+
+// wrote records the merges written along with another one
+func (d *targetDebouncer) wrote(merges map[types.NamespacedName]int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.written == nil {
+		d.written = map[types.NamespacedName]int64{}
+	}
+	for merge, generation := range merges {
+		d.written[merge] = generation
+	}
+}
+
+// holdsBack checks if the change of the patch is held back to be written along with other ones.
+// The new, deleted and retargeted patches are reconciled right away.
+func (r *VirtualServicePatchReconciler) holdsBack(patch, oldPatch *v1alpha1.VirtualServiceMerge) bool {
+	if r.Options.Debounce.Window <= 0 || !patch.DeletionTimestamp.IsZero() ||
+		!oputil.ContainsWithPrefix(patch.Finalizers, finalizerName) {
+		return false
+	}
+	if patch.Status.ObservedGeneration == patch.Generation {
+		// the target or the policies changed, not the patch
+		return false
+	}
+	return oldPatch == nil || oldPatch.TargetKey() == patch.TargetKey()
+}
+
+// writeBatch merges the held back changes of the merges of the target of the patch into it
+// with a single write, and then updates their statuses
+func (r *VirtualServicePatchReconciler) writeBatch(ctx reconciler.Context, istioClient versionedclient.Interface,
+	patch *v1alpha1.VirtualServiceMerge, merges []types.NamespacedName) error {
+	target := patch.TargetKey()
+	ctx.Logger().Info("Merging the held back patches into the target",
+		logging.KeyAction, logging.ActionMerge, "merges", len(merges))
+	targets := &batchedTargetClient{Interface: istioClient, target: target}
+	statuses := &deferredStatusClient{Client: ctx.Client()}
+	batchCtx := withClient(ctx, statuses)
+	opts := r.Options
+	// the revision of the single write is recorded once
+	opts.RevisionHistoryLimit = 0
+	for _, key := range merges {
+		merge := patch
+		if key != (types.NamespacedName{Namespace: patch.Namespace, Name: patch.Name}) {
+			merge = &v1alpha1.VirtualServiceMerge{}
+			if err := ctx.Client().Get(context.TODO(), key, merge); kerr.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if merge.TargetKey() != target || !r.holdsBack(merge, nil) {
+				// left to its own reconcile
+				continue
+			}
+			if allowed, err := r.Options.Scope.allowsMerge(ctx.Client(), merge); err != nil {
+				return err
+			} else if !allowed {
+				continue
+			}
+		}
+		mergeCtx := withValues(batchCtx, logging.KeyBatchedMerge, key.String())
+		if err := Reconcile(mergeCtx, targets, merge, nil, opts); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	spanCtx, span := startSpan(ctx, "UpdateTarget", attribute.String(logging.KeyAction, logging.ActionMerge),
+		attribute.Int("merges", len(merges)))
+	updated, err := targets.flush(requestContext(spanCtx))
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	if updated != nil {
+		batchedWrites.WithLabelValues(target.Namespace, target.Name).Inc()
+		recordRevision(ctx, updated, r.Options)
+	}
+	written := map[types.NamespacedName]int64{}
+	for _, obj := range statuses.updates {
+		merge, ok := obj.(*v1alpha1.VirtualServiceMerge)
+		if !ok {
+			continue
+		}
+		if status := merge.Status.Target; updated != nil && status != nil && status.UID == updated.UID {
+			// the generation of the single write
+			status.Generation = updated.Generation
+		}
+		written[types.NamespacedName{Namespace: merge.Namespace, Name: merge.Name}] = merge.Generation
+	}
+	if err := statuses.flush(context.TODO()); err != nil {
+		return err
+	}
+	r.debouncer.wrote(written)
+	return nil
+}
+
+// batchedTargetClient holds the writes of the target in memory until flushed,
+// and passes the requests about the other VirtualServices through
+type batchedTargetClient struct {
+	versionedclient.Interface
+	target types.NamespacedName
+	// read is the target as read from the API server, and written as merged in memory
+	read, written *istio.VirtualService
+}
+
+func (c *batchedTargetClient) NetworkingV1alpha3() networkingv1alpha3.NetworkingV1alpha3Interface {
+	return batchedNetworking{NetworkingV1alpha3Interface: c.Interface.NetworkingV1alpha3(), batch: c}
+}
+
+// flush writes the target once, if changed, and returns it then
+func (c *batchedTargetClient) flush(ctx context.Context) (*istio.VirtualService, error) {
+	if c.written == nil || isMerged(c.written, c.read) {
+		return nil, nil
+	}
+	return c.Interface.NetworkingV1alpha3().VirtualServices(c.target.Namespace).
+		Update(ctx, c.written, metav1.UpdateOptions{})
+}
+
+type batchedNetworking struct {
+	networkingv1alpha3.NetworkingV1alpha3Interface
+	batch *batchedTargetClient
+}
+
+func (n batchedNetworking) VirtualServices(namespace string) networkingv1alpha3.VirtualServiceInterface {
+	services := n.NetworkingV1alpha3Interface.VirtualServices(namespace)
+	if namespace != n.batch.target.Namespace {
+		return services
+	}
+	return batchedVirtualServices{VirtualServiceInterface: services, batch: n.batch}
+}
+
+type batchedVirtualServices struct {
+	networkingv1alpha3.VirtualServiceInterface
+	batch *batchedTargetClient
+}
+
+func (s batchedVirtualServices) Get(ctx context.Context, name string, opts metav1.GetOptions) (*istio.VirtualService, error) {
+	if name != s.batch.target.Name {
+		return s.VirtualServiceInterface.Get(ctx, name, opts)
+	}
+	if s.batch.written == nil {
+		read, err := s.VirtualServiceInterface.Get(ctx, name, opts)
+		if err != nil {
+			return nil, err
+		}
+		s.batch.read, s.batch.written = read, read.DeepCopy()
+	}
+	return s.batch.written.DeepCopy(), nil
+}
+
+func (s batchedVirtualServices) Update(ctx context.Context, service *istio.VirtualService, opts metav1.UpdateOptions) (*istio.VirtualService, error) {
+	if service.Name != s.batch.target.Name || len(opts.DryRun) > 0 || s.batch.written == nil {
+		return s.VirtualServiceInterface.Update(ctx, service, opts)
+	}
+	s.batch.written = service.DeepCopy()
+	return service.DeepCopy(), nil
+}
+
+// deferredStatusClient holds the status updates until flushed, so that
+// the statuses are written once the target is
+type deferredStatusClient struct {
+	client.Client
+	updates []client.Object
+}
+
+func (c *deferredStatusClient) Status() client.StatusWriter {
+	return deferredStatusWriter{StatusWriter: c.Client.Status(), deferred: c}
+}
+
+// flush writes the held status updates
+func (c *deferredStatusClient) flush(ctx context.Context) error {
+	var errs []error
+	for _, obj := range c.updates {
+		if err := c.Client.Status().Update(ctx, obj); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.updates = nil
+	return utilerrors.NewAggregate(errs)
+}
+
+type deferredStatusWriter struct {
+	client.StatusWriter
+	deferred *deferredStatusClient
+}
+
+func (w deferredStatusWriter) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	w.deferred.updates = append(w.deferred.updates, obj)
+	return nil
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/tests/mocks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	istiofake "istio.io/client-go/pkg/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("targetDebouncer", func() {
+	target := types.NamespacedName{Namespace: "app-space", Name: "gateway-routes"}
+	reviews := types.NamespacedName{Namespace: "app-space", Name: "review-routes"}
+	ratings := types.NamespacedName{Namespace: "app-space", Name: "rating-routes"}
+	opts := Debounce{Window: time.Second, MaxDelay: 3 * time.Second}
+
+	Context("method hold(target, merge, generation, now, opts)", func() {
+		It("holds back the changes until none arrived for the window", func() {
+			var debouncer targetDebouncer
+			start := time.Now()
+			coalesced := testutil.ToFloat64(coalescedEvents.WithLabelValues(target.Namespace, target.Name))
+
+			wait, batch := debouncer.hold(target, reviews, 2, start, opts)
+			Expect(wait).To(Equal(time.Second))
+			Expect(batch).To(BeNil())
+			wait, _ = debouncer.hold(target, ratings, 1, start.Add(500*time.Millisecond), opts)
+			Expect(wait).To(Equal(time.Second))
+			// the requeue of a held back change is not a new change
+			wait, _ = debouncer.hold(target, ratings, 1, start.Add(time.Second), opts)
+			Expect(wait).To(Equal(500 * time.Millisecond))
+
+			wait, batch = debouncer.hold(target, reviews, 2, start.Add(1500*time.Millisecond), opts)
+			Expect(wait).To(BeZero())
+			Expect(batch).To(Equal([]types.NamespacedName{ratings, reviews}))
+			Expect(testutil.ToFloat64(coalescedEvents.WithLabelValues(target.Namespace, target.Name))).
+				To(Equal(coalesced + 1))
+		})
+
+		It("holds back the changes no longer than the max delay", func() {
+			var debouncer targetDebouncer
+			start := time.Now()
+			debouncer.hold(target, reviews, 1, start, opts)
+			// a change every 800ms would hold the others back forever
+			for i, expected := range []time.Duration{time.Second, time.Second, 600 * time.Millisecond} {
+				now := start.Add(time.Duration(i+1) * 800 * time.Millisecond)
+				wait, _ := debouncer.hold(target, reviews, int64(i+2), now, opts)
+				Expect(wait).To(Equal(expected))
+			}
+			_, batch := debouncer.hold(target, reviews, 5, start.Add(3*time.Second), opts)
+			Expect(batch).To(Equal([]types.NamespacedName{reviews}))
+		})
+	})
+
+	Context("method writeBatch(ctx, client, patch, merges)", func() {
+		newMerge := func(name, prefix string) *v1alpha1.VirtualServiceMerge {
+			return &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: name, Namespace: target.Namespace, Generation: 1,
+					Finalizers: []string{finalizerName}},
+				Spec: v1alpha1.VirtualServiceMergeSpec{
+					Target: v1alpha1.Target{Name: target.Name},
+					Patch: networkingv1alpha3.VirtualService{Http: []*networkingv1alpha3.HTTPRoute{{
+						Match: []*networkingv1alpha3.HTTPMatchRequest{{Uri: &networkingv1alpha3.StringMatch{
+							MatchType: &networkingv1alpha3.StringMatch_Prefix{Prefix: prefix}}}},
+						Route: []*networkingv1alpha3.HTTPRouteDestination{{
+							Destination: &networkingv1alpha3.Destination{Host: name}}},
+					}}},
+				},
+			}
+		}
+
+		It("merges the held back patches into the target with a single write", func() {
+			reviewMerge, ratingMerge := newMerge(reviews.Name, "/reviews"), newMerge(ratings.Name, "/ratings")
+			scheme := runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(reviewMerge, ratingMerge).Build()
+			istioClient := istiofake.NewSimpleClientset(&istio.VirtualService{
+				ObjectMeta: v1.ObjectMeta{Name: target.Name, Namespace: target.Namespace, UID: "target-uid", Generation: 3},
+				Spec:       networkingv1alpha3.VirtualService{Hosts: []string{"api.example.com"}},
+			})
+			ctx := mocks.NewMockContext(gomock.NewController(GinkgoT()))
+			ctx.EXPECT().Client().Return(c).AnyTimes()
+			ctx.EXPECT().Logger().Return(logr.Discard()).AnyTimes()
+			r := &VirtualServicePatchReconciler{Options: Options{Debounce: opts}}
+
+			Expect(c.Get(context.TODO(), reviews, reviewMerge)).To(Succeed())
+			Expect(r.writeBatch(ctx, istioClient, reviewMerge, []types.NamespacedName{ratings, reviews})).To(Succeed())
+
+			updates := 0
+			for _, action := range istioClient.Actions() {
+				if action.GetVerb() == "update" {
+					updates++
+				}
+			}
+			Expect(updates).To(Equal(1))
+			written, err := istioClient.NetworkingV1alpha3().VirtualServices(target.Namespace).
+				Get(context.TODO(), target.Name, v1.GetOptions{})
+			Expect(err).To(BeNil())
+			Expect(written.Spec.Http).To(HaveLen(2))
+			for _, key := range []types.NamespacedName{reviews, ratings} {
+				merge := &v1alpha1.VirtualServiceMerge{}
+				Expect(c.Get(context.TODO(), key, merge)).To(Succeed())
+				Expect(meta.IsStatusConditionTrue(merge.Status.Conditions, v1alpha1.ConditionApplied)).To(BeTrue())
+				Expect(merge.Status.Target.UID).To(Equal(types.UID("target-uid")))
+			}
+			Expect(r.debouncer.batched(ratings, 1)).To(BeTrue())
+			Expect(r.debouncer.batched(ratings, 1)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/reconciler"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errDelegateTaken is returned when the delegate VirtualService of a patch is not controlled by the patch
+var errDelegateTaken = errors.New("delegate taken")
+
+// syncDelegate creates or updates the delegate VirtualService holding the patch http routes.
+// A VirtualService of the delegate name controlled by anything else is left alone.
+func syncDelegate(ctx reconciler.Context, istioClient versionedclient.Interface,
+	patch *v1alpha1.VirtualServiceMerge, violations []v1alpha1.RouteViolation) error {
+	routes := patch
+	if len(violations) > 0 {
+		routes = patch.WithoutRoutes(ctx.Logger(), violations)
+	}
+	desired := routes.NewDelegate(ctx.Logger())
+	if err := ctx.SetOwnershipReference(patch, desired); err != nil {
+		return err
+	}
+	delegates := istioClient.NetworkingV1alpha3().VirtualServices(desired.Namespace)
+	current, err := delegates.Get(context.TODO(), desired.Name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		ctx.Logger().Info("Creating the delegate virtual service", logging.KeyAction, logging.ActionDelegate, "delegate", desired.Name)
+		_, err = delegates.Create(context.TODO(), desired, metav1.CreateOptions{})
+		return err
+	} else if err != nil {
+		return err
+	}
+	if !ownsDelegate(patch, current) {
+		return fmt.Errorf("%w: the VirtualService %s/%s is not controlled by the merge", errDelegateTaken, current.Namespace, current.Name)
+	}
+	if proto.Equal(&current.Spec, &desired.Spec) {
+		return nil
+	}
+	ctx.Logger().Info("Updating the delegate virtual service", logging.KeyAction, logging.ActionDelegate, "delegate", desired.Name)
+	current = current.DeepCopy()
+	current.Spec = desired.Spec
+	_, err = delegates.Update(context.TODO(), current, metav1.UpdateOptions{})
+	return err
+}
+
+// releaseDelegate deletes the delegate VirtualService of the patch
+// once the target no longer delegates to it, unless something else controls it
+func releaseDelegate(ctx reconciler.Context, istioClient versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge) error {
+	key := patch.DelegateKey()
+	delegates := istioClient.NetworkingV1alpha3().VirtualServices(key.Namespace)
+	current, err := delegates.Get(context.TODO(), key.Name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !ownsDelegate(patch, current) {
+		ctx.Logger().Info("Delegate virtual service not controlled by the patch. Leaving it.",
+			logging.KeyAction, logging.ActionSkip, "delegate", key.Name)
+		return nil
+	}
+	ctx.Logger().Info("Deleting the delegate virtual service", logging.KeyAction, logging.ActionDelete, "delegate", key.Name)
+	err = delegates.Delete(context.TODO(), key.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &current.UID}})
+	if kerr.IsNotFound(err) || kerr.IsConflict(err) {
+		// already deleted, or recreated by someone else
+		return nil
+	}
+	return err
+}
+
+// ownsDelegate checks if the patch is the controller of the delegate VirtualService
+func ownsDelegate(patch *v1alpha1.VirtualServiceMerge, delegate *istio.VirtualService) bool {
+	owner := metav1.GetControllerOf(delegate)
+	return owner != nil && owner.Kind == "VirtualServiceMerge" && owner.UID == patch.UID
+}
+
+// delegatesTo checks if a route of the target delegates to the delegate VirtualService of the patch
+func delegatesTo(target *istio.VirtualService, patch *v1alpha1.VirtualServiceMerge) bool {
+	key := patch.DelegateKey()
+	for _, route := range target.Spec.Http {
+		if route.Delegate != nil && route.Delegate.Name == key.Name && route.Delegate.Namespace == key.Namespace {
+			return true
+		}
+	}
+	return false
+}
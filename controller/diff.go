@@ -0,0 +1,87 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	"github.com/monimesl/operator-helper/reconciler"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// specDiff returns the unified diff of the target specs
+func specDiff(before, after *istio.VirtualService) (string, error) {
+	a, err := diff.Marshal(&before.Spec, "yaml")
+	if err != nil {
+		return "", err
+	}
+	b, err := diff.Marshal(&after.Spec, "yaml")
+	if err != nil {
+		return "", err
+	}
+	return diff.Lines(string(a), string(b), 3), nil
+}
+
+// recordDiff keeps the change the patch made to the target in its status and,
+// when enabled, in full in a ConfigMap. Both are for audit only so failures are just logged.
+func recordDiff(ctx reconciler.Context, patch *v1alpha1.VirtualServiceMerge, before, after *istio.VirtualService, opts Options) {
+	change, err := diff.Targets(&before.Spec, &after.Spec)
+	if err != nil {
+		ctx.Logger().Error(err, "Failed to compute the change of the target")
+		return
+	}
+	if change.Empty() {
+		return
+	}
+	patch.Status.LastDiff = change.Bounded(v1alpha1.MaxDiffEntries)
+	if opts.DiffConfigMap {
+		if err := writeDiffConfigMap(ctx, patch, change, before, after); err != nil {
+			ctx.Logger().Error(err, "Failed to write the change of the target to a ConfigMap")
+		}
+	}
+}
+
+// DiffConfigMapName is the name of the ConfigMap holding the last full diff of the patch
+func DiffConfigMapName(patch *v1alpha1.VirtualServiceMerge) string {
+	return patch.Name + "-diff"
+}
+
+func writeDiffConfigMap(ctx reconciler.Context, patch *v1alpha1.VirtualServiceMerge, change *v1alpha1.TargetDiff, before, after *istio.VirtualService) error {
+	summary, err := diff.Marshal(change, "yaml")
+	if err != nil {
+		return err
+	}
+	text, err := specDiff(before, after)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: DiffConfigMapName(patch), Namespace: patch.Namespace}}
+	_, err = controllerutil.CreateOrUpdate(context.TODO(), ctx.Client(), cm, func() error {
+		cm.Data = map[string]string{
+			"target":    patch.TargetKey().String(),
+			"diff.yaml": string(summary),
+			"spec.diff": text,
+		}
+		return ctx.SetOwnershipReference(patch, cm)
+	})
+	return err
+}
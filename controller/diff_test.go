@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/tests/mocks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Target diff", func() {
+	Context("method Bounded(max)", func() {
+		It("bounds the lists", func() {
+			diff := &v1alpha1.TargetDiff{Added: []string{"http/a-2", "http/b-1", "http/c-0"}}
+			bounded := diff.Bounded(2)
+			Expect(bounded.Added).To(HaveLen(2))
+			Expect(bounded.Truncated).To(BeTrue())
+			Expect(diff.Added).To(HaveLen(3))
+		})
+	})
+
+	Context("method recordDiff(ctx, patch, before, after, opts)", func() {
+		newTarget := func(host string) *istio.VirtualService {
+			return &istio.VirtualService{
+				ObjectMeta: v1.ObjectMeta{Name: "api-routes", Namespace: "app-space"},
+				Spec: networkingv1alpha3.VirtualService{Http: []*networkingv1alpha3.HTTPRoute{{
+					Name:  "review-routes-0",
+					Route: []*networkingv1alpha3.HTTPRouteDestination{{Destination: &networkingv1alpha3.Destination{Host: host}}},
+				}}},
+			}
+		}
+
+		It("writes the full diff to the ConfigMap of the patch", func() {
+			patch := &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: "review-routes", Namespace: "app-space"},
+				Spec:       v1alpha1.VirtualServiceMergeSpec{Target: v1alpha1.Target{Name: "api-routes"}},
+			}
+			scheme := runtime.NewScheme()
+			Expect(corev1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).Build()
+			mockCtx := mocks.NewMockContext(gomock.NewController(GinkgoT()))
+			mockCtx.EXPECT().Client().Return(c).AnyTimes()
+			mockCtx.EXPECT().Logger().Return(logr.Discard()).AnyTimes()
+			mockCtx.EXPECT().SetOwnershipReference(patch, gomock.Any()).Return(nil).Times(2)
+			key := types.NamespacedName{Namespace: "app-space", Name: DiffConfigMapName(patch)}
+			cm := &corev1.ConfigMap{}
+
+			recordDiff(mockCtx, patch, newTarget("reviews"), newTarget("reviews-v2"), Options{DiffConfigMap: true})
+			Expect(patch.Status.LastDiff.Replaced).To(HaveLen(1))
+			Expect(c.Get(context.TODO(), key, cm)).To(Succeed())
+			Expect(cm.Data["target"]).To(Equal("app-space/api-routes"))
+			Expect(cm.Data["diff.yaml"]).To(ContainSubstring("route: http/review-routes-0"))
+			Expect(cm.Data["spec.diff"]).To(ContainSubstring("+      host: reviews-v2\n"))
+
+			recordDiff(mockCtx, patch, newTarget("reviews-v2"), newTarget("reviews-v3"), Options{DiffConfigMap: true})
+			Expect(c.Get(context.TODO(), key, cm)).To(Succeed())
+			Expect(cm.Data["spec.diff"]).To(ContainSubstring("-      host: reviews-v2\n"))
+			Expect(cm.Data["spec.diff"]).To(ContainSubstring("+      host: reviews-v3\n"))
+
+			// an unchanged target leaves the last diff in place
+			recordDiff(mockCtx, patch, newTarget("reviews-v3"), newTarget("reviews-v3"), Options{DiffConfigMap: true})
+			Expect(c.Get(context.TODO(), key, cm)).To(Succeed())
+			Expect(cm.Data["spec.diff"]).To(ContainSubstring("+      host: reviews-v3\n"))
+		})
+	})
+})
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/reconciler"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// isDryRun checks if the target must be left unchanged, globally or for this patch
+func isDryRun(patch *v1alpha1.VirtualServiceMerge, opts Options) bool {
+	return opts.DryRun || patch.Spec.DryRun
+}
+
+// updateOptions validates the target update with the API server without persisting it in a dry run
+func updateOptions(patch *v1alpha1.VirtualServiceMerge, opts Options) metav1.UpdateOptions {
+	if isDryRun(patch, opts) {
+		return metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.UpdateOptions{}
+}
+
+// truncateDiff cuts the diff to at most max bytes, marker included, on a rune
+// boundary so that a multi-byte character is never split into an invalid string
+func truncateDiff(diff string, max int) string {
+	const marker = "\n... truncated"
+	if len(diff) <= max {
+		return diff
+	}
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(diff[cut]) {
+		cut--
+	}
+	return diff[:cut] + marker
+}
+
+// dryRunTarget reports in the status, an event and the logs the change
+// the patch would make to the target, which the API server validates
+func dryRunTarget(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge,
+	target, desired *istio.VirtualService, violations []v1alpha1.RouteViolation, opts Options) error {
+	diff, err := specDiff(target, desired)
+	if err != nil {
+		return err
+	}
+	diff = truncateDiff(diff, v1alpha1.MaxDryRunDiffLength)
+	message := "Dry run: the target already holds the patch"
+	if diff != "" {
+		message = "Dry run: the patch would change the target"
+	}
+	if len(violations) > 0 {
+		message += ". " + violationsMessage(violations)
+	}
+	current := meta.FindStatusCondition(patch.Status.Conditions, v1alpha1.ConditionApplied)
+	if current != nil && current.Reason == v1alpha1.ReasonDryRun && current.Message == message &&
+		patch.Status.ObservedGeneration == patch.Generation && patch.Status.DryRunDiff == diff {
+		// already reported
+		return nil
+	}
+	if _, err := client.NetworkingV1alpha3().VirtualServices(desired.Namespace).
+		Update(context.TODO(), desired, updateOptions(patch, opts)); err != nil {
+		return err
+	}
+	ctx.Logger().Info(message, logging.KeyAction, logging.ActionSkip, "diff", diff)
+	opts.event(ctx, patch, corev1.EventTypeNormal, v1alpha1.ReasonDryRun, "%s %s", message, patch.TargetKey())
+	meta.SetStatusCondition(&patch.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1alpha1.ReasonDryRun,
+		Message:            message,
+		ObservedGeneration: patch.Generation,
+	})
+	patch.Status.Target = nil
+	patch.Status.DryRunDiff = diff
+	return updateStatus(ctx, patch)
+}
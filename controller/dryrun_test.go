@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dry run", func() {
+	Context("method truncateDiff(diff, max)", func() {
+		DescribeTable("cuts the long diffs on a rune boundary",
+			func(diff string, max int, expected string) {
+				truncated := truncateDiff(diff, max)
+				Expect(truncated).To(Equal(expected))
+				Expect(len(truncated)).To(BeNumerically("<=", max))
+				Expect(utf8.ValidString(truncated)).To(BeTrue())
+			},
+			Entry("within the bound", "+  host: é", 32, "+  host: é"),
+			Entry("of single byte runes", strings.Repeat("a", 40), 32, strings.Repeat("a", 18)+"\n... truncated"),
+			// é is two bytes, the 18 bytes bound falls in the middle of the tenth one
+			Entry("of multi-byte runes", strings.Repeat("é", 20), 32, strings.Repeat("é", 9)+"\n... truncated"),
+		)
+	})
+})
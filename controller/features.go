@@ -0,0 +1,71 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	// FeatureTargetCreation creates the missing targets of the patches asking for it
+	FeatureTargetCreation = "TargetCreation"
+	// FeatureDriftCorrection merges the patches again into their targets edited by hand
+	FeatureDriftCorrection = "DriftCorrection"
+	// FeatureManagedTargetDeletion deletes the created targets no patch merges into anymore
+	FeatureManagedTargetDeletion = "ManagedTargetDeletion"
+)
+
+// defaultFeatures are the optional merge behaviors and whether they are enabled by default
+var defaultFeatures = map[string]bool{
+	FeatureTargetCreation:        true,
+	FeatureDriftCorrection:       true,
+	FeatureManagedTargetDeletion: true,
+}
+
+// FeatureGates enables or disables the optional merge behaviors by name
+type FeatureGates map[string]bool
+
+// Enabled checks if the feature is enabled, falling back to its default
+func (g FeatureGates) Enabled(feature string) bool {
+	if enabled, set := g[feature]; set {
+		return enabled
+	}
+	return defaultFeatures[feature]
+}
+
+// Validate rejects the unknown features
+func (g FeatureGates) Validate() error {
+	var errs []error
+	for feature := range g {
+		if _, known := defaultFeatures[feature]; !known {
+			errs = append(errs, fmt.Errorf("unknown feature gate %q, expected one of %v", feature, knownFeatures()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func knownFeatures() []string {
+	features := make([]string, 0, len(defaultFeatures))
+	for feature := range defaultFeatures {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FeatureGates", func() {
+	It("enables the features by default", func() {
+		var gates FeatureGates
+		Expect(gates.Enabled(FeatureTargetCreation)).To(BeTrue())
+		Expect(gates.Enabled(FeatureDriftCorrection)).To(BeTrue())
+		Expect(gates.Enabled(FeatureManagedTargetDeletion)).To(BeTrue())
+	})
+
+	It("disables the features set to false", func() {
+		gates := FeatureGates{FeatureDriftCorrection: false}
+		Expect(gates.Enabled(FeatureDriftCorrection)).To(BeFalse())
+		Expect(gates.Enabled(FeatureTargetCreation)).To(BeTrue())
+		Expect(gates.Validate()).To(Succeed())
+	})
+
+	It("rejects the unknown features", func() {
+		Expect(FeatureGates{"Sharding": true}.Validate()).To(MatchError(ContainSubstring(`unknown feature gate "Sharding"`)))
+	})
+})
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// cacheSyncTimeout bounds how long a readiness check waits for the cache
+const cacheSyncTimeout = time.Second
+
+// maxListedMerges bounds the merges named by a failing readiness check
+const maxListedMerges = 5
+
+// tenantReasons are the reasons of the merges not Applied by the choice or the mistake of their owners,
+// which the operator cannot converge
+var tenantReasons = map[string]bool{
+	v1alpha1.ReasonForbidden:     true,
+	v1alpha1.ReasonTargetMissing: true,
+	v1alpha1.ReasonPaused:        true,
+	v1alpha1.ReasonDryRun:        true,
+	v1alpha1.ReasonDelegateTaken: true,
+}
+
+// addHealthChecks registers the liveness and readiness checks of the operator with the manager
+func addHealthChecks(mgr manager.Manager, opts Options) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("discovery client create error: %w", err)
+	}
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+	synced := cacheSynced(mgr.GetCache())
+	if err := mgr.AddReadyzCheck("cache-sync", synced); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("istio-crd", istioAvailable(dc)); err != nil {
+		return err
+	}
+	return mgr.AddReadyzCheck("merges-applied", mergesApplied(synced, mgr.GetClient(), opts))
+}
+
+// cacheSynced checks that the informers of the operator have synced
+func cacheSynced(c cache.Cache) healthz.Checker {
+	return func(_ *http.Request) error {
+		ctx, cancel := context.WithTimeout(context.Background(), cacheSyncTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("the cache has not synced")
+		}
+		return nil
+	}
+}
+
+// istioAvailable checks that the API server serves the Istio VirtualServices
+func istioAvailable(dc discovery.DiscoveryInterface) healthz.Checker {
+	return func(_ *http.Request) error {
+		resources, err := dc.ServerResourcesForGroupVersion(istio.SchemeGroupVersion.String())
+		if err != nil {
+			return fmt.Errorf("the %s API is not served: %w", istio.SchemeGroupVersion, err)
+		}
+		for _, resource := range resources.APIResources {
+			if resource.Name == "virtualservices" {
+				return nil
+			}
+		}
+		return fmt.Errorf("the virtualservices of %s are not served", istio.SchemeGroupVersion)
+	}
+}
+
+// mergesApplied counts the merges in scope the operator failed to apply once synced and, with Options.ReadyOnConvergence,
+// reports the operator not ready while there are more than Options.MaxUnappliedMerges of them
+func mergesApplied(synced healthz.Checker, c client.Client, opts Options) healthz.Checker {
+	return func(req *http.Request) error {
+		// the reads of the cache block until it syncs
+		if err := synced(req); err != nil {
+			return err
+		}
+		unapplied, err := listUnappliedMerges(c, opts.Scope)
+		if err != nil {
+			return err
+		}
+		unappliedMerges.Set(float64(len(unapplied)))
+		if !opts.ReadyOnConvergence || len(unapplied) <= opts.MaxUnappliedMerges {
+			return nil
+		}
+		listed := unapplied
+		if len(listed) > maxListedMerges {
+			listed = append(listed[:maxListedMerges:maxListedMerges], "...")
+		}
+		return fmt.Errorf("%d VirtualServiceMerges are not Applied: %s", len(unapplied), strings.Join(listed, ", "))
+	}
+}
+
+// listUnappliedMerges lists the merges in scope whose current generation the operator has not applied,
+// as an API error or a write conflict left its status behind, leaving out the ones not Applied for a tenant reason
+func listUnappliedMerges(c client.Client, scope Scope) ([]string, error) {
+	merges := &v1alpha1.VirtualServiceMergeList{}
+	if err := c.List(context.TODO(), merges); err != nil {
+		return nil, err
+	}
+	var unapplied []string
+	for i := range merges.Items {
+		merge := &merges.Items[i]
+		if !merge.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if allowed, err := scope.allowsMerge(c, merge); err != nil {
+			return nil, err
+		} else if !allowed {
+			continue
+		}
+		condition := meta.FindStatusCondition(merge.Status.Conditions, v1alpha1.ConditionApplied)
+		if condition == nil || condition.ObservedGeneration != merge.Generation ||
+			condition.Status != metav1.ConditionTrue && !tenantReasons[condition.Reason] {
+			unapplied = append(unapplied, merge.Namespace+"/"+merge.Name)
+		}
+	}
+	return unapplied, nil
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+var _ = Describe("Health", func() {
+	Context("check mergesApplied", func() {
+		newMerge := func(name string, applied v1.ConditionStatus, reason string, observed int64) *v1alpha1.VirtualServiceMerge {
+			merge := &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "team-a", Generation: 2},
+				Spec: v1alpha1.VirtualServiceMergeSpec{
+					Target: v1alpha1.Target{Name: "api-routes"},
+				},
+			}
+			if applied != "" {
+				merge.Status.Conditions = []v1.Condition{{
+					Type: v1alpha1.ConditionApplied, Status: applied, Reason: reason, ObservedGeneration: observed,
+				}}
+			}
+			return merge
+		}
+
+		DescribeTable("reports the merges the operator failed to apply",
+			func(opts Options, ready bool) {
+				scheme := runtime.NewScheme()
+				Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+				c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+					newMerge("applied", v1.ConditionTrue, v1alpha1.ReasonApplied, 2),
+					newMerge("forbidden", v1.ConditionFalse, v1alpha1.ReasonForbidden, 2),
+					newMerge("missing", v1.ConditionFalse, v1alpha1.ReasonTargetMissing, 2),
+					newMerge("paused", v1.ConditionFalse, v1alpha1.ReasonPaused, 2),
+					newMerge("dry-run", v1.ConditionFalse, v1alpha1.ReasonDryRun, 2),
+					newMerge("taken", v1.ConditionFalse, v1alpha1.ReasonDelegateTaken, 2),
+					newMerge("failed", v1.ConditionFalse, "Unknown", 2),
+					newMerge("stale", v1.ConditionTrue, v1alpha1.ReasonApplied, 1),
+					newMerge("new", "", "", 0),
+				).Build()
+				err := mergesApplied(healthz.Ping, c, opts)(&http.Request{})
+				if ready {
+					Expect(err).To(BeNil())
+				} else {
+					Expect(err).To(MatchError("3 VirtualServiceMerges are not Applied: team-a/failed, team-a/new, team-a/stale"))
+				}
+			},
+			Entry("without gating the readiness", Options{}, true),
+			Entry("above the tolerated count", Options{ReadyOnConvergence: true, MaxUnappliedMerges: 2}, false),
+			Entry("within the tolerated count", Options{ReadyOnConvergence: true, MaxUnappliedMerges: 3}, true),
+			Entry("outside of the scope", Options{ReadyOnConvergence: true, Scope: Scope{Namespaces: []string{"team-b"}}}, true),
+		)
+
+		It("will not read the cache before it syncs", func() {
+			unsynced := func(_ *http.Request) error { return errors.New("the cache has not synced") }
+			err := mergesApplied(unsynced, nil, Options{ReadyOnConvergence: true})(&http.Request{})
+			Expect(err).To(MatchError("the cache has not synced"))
+		})
+	})
+})
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// targetIndexKey indexes the VirtualServiceMerges by the namespace/name of their target
+const targetIndexKey = "spec.target.key"
+
+// RegisterTargetIndex adds the target index of the VirtualServiceMerges to the manager cache
+func RegisterTargetIndex(ctx context.Context, indexer client.FieldIndexer) error {
+	return indexer.IndexField(ctx, &v1alpha1.VirtualServiceMerge{}, targetIndexKey, func(obj client.Object) []string {
+		return []string{obj.(*v1alpha1.VirtualServiceMerge).TargetKey().String()}
+	})
+}
+
+// mergesTargeting maps a target to the requests of the merges into it across all namespaces.
+// The cache lookup is retried with a bounded backoff before the event is given up, after which
+// only the resync of the merges, when Options.ResyncPeriod is set, repairs what the event missed.
+func (r *VirtualServicePatchReconciler) mergesTargeting(target types.NamespacedName) []reconcile.Request {
+	requests := make([]reconcile.Request, 0)
+	vsmergeList := &v1alpha1.VirtualServiceMergeList{}
+	err := retry.OnError(retry.DefaultBackoff, func(error) bool { return true }, func() error {
+		return r.Client().List(context.TODO(), vsmergeList, client.MatchingFields{targetIndexKey: target.String()})
+	})
+	if err != nil {
+		r.Logger().Error(err, "Failed to list the merges into the target", logging.KeyTarget, target.String())
+		return requests
+	}
+	for _, vsmerge := range vsmergeList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: vsmerge.GetNamespace(),
+				Name:      vsmerge.GetName(),
+			},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/tests/mocks"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// failingListClient fails its first lists
+type failingListClient struct {
+	client.Client
+	failures int
+}
+
+func (c *failingListClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("the cache is not reachable")
+	}
+	return c.Client.List(ctx, list, opts...)
+}
+
+var _ = Describe("Target index", func() {
+	Context("method mergesTargeting(target)", func() {
+		target := types.NamespacedName{Namespace: "gateway", Name: "api-routes"}
+
+		mergesTargeting := func(failures int) []reconcile.Request {
+			scheme := runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: "review-routes", Namespace: "team-a"},
+				Spec:       v1alpha1.VirtualServiceMergeSpec{Target: v1alpha1.Target{Name: target.Name, Namespace: target.Namespace}},
+			}).Build()
+			ctx := mocks.NewMockContext(gomock.NewController(GinkgoT()))
+			ctx.EXPECT().Client().Return(&failingListClient{Client: c, failures: failures}).AnyTimes()
+			ctx.EXPECT().Logger().Return(logr.Discard()).AnyTimes()
+			r := &VirtualServicePatchReconciler{Context: ctx}
+			return r.mergesTargeting(target)
+		}
+
+		It("retries a failed lookup", func() {
+			Expect(mergesTargeting(2)).To(Equal([]reconcile.Request{
+				{NamespacedName: types.NamespacedName{Namespace: "team-a", Name: "review-routes"}},
+			}))
+		})
+
+		It("gives the event up once the retries are exhausted", func() {
+			Expect(mergesTargeting(10)).To(BeEmpty())
+		})
+	})
+})
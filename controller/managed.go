@@ -0,0 +1,86 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/reconciler"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReasonTargetCreated is the reason of the event recorded when a patch creates its target
+const ReasonTargetCreated = "TargetCreated"
+
+// createTarget creates the missing target of the patch from its template,
+// if the patch is in the target namespace or a policy of the target allows it
+func createTarget(ctx reconciler.Context, istioClient versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, opts Options) (*istio.VirtualService, error) {
+	key := patch.TargetKey()
+	policies := &v1alpha1.MergePolicyList{}
+	if err := ctx.Client().List(context.TODO(), policies); err != nil {
+		return nil, err
+	}
+	if err := policies.AdmitCreation(patch); err != nil {
+		return nil, err
+	}
+	ctx.Logger().Info("Virtual service not found. Creating it from the template.",
+		logging.KeyAction, logging.ActionCreate)
+	target, err := istioClient.NetworkingV1alpha3().VirtualServices(key.Namespace).
+		Create(context.TODO(), patch.Spec.Target.CreateIfMissing.NewTarget(patch), metav1.CreateOptions{})
+	if kerr.IsAlreadyExists(err) {
+		// created meanwhile by another patch
+		return getTarget(ctx, istioClient, patch)
+	} else if err != nil {
+		return nil, err
+	}
+	opts.event(ctx, patch, corev1.EventTypeNormal, ReasonTargetCreated, "Created the target %s from the template", key)
+	return target, nil
+}
+
+// deleteUnreferencedTarget deletes the target created by the operator
+// once no other patch than the given one references it
+func deleteUnreferencedTarget(ctx reconciler.Context, istioClient versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, target *istio.VirtualService) error {
+	if target.Labels[v1alpha1.LabelManaged] != "true" {
+		return nil
+	}
+	merges := &v1alpha1.VirtualServiceMergeList{}
+	key := target.Namespace + "/" + target.Name
+	if err := ctx.Client().List(context.TODO(), merges, client.MatchingFields{targetIndexKey: key}); err != nil {
+		return err
+	}
+	for _, merge := range merges.Items {
+		if merge.UID != patch.UID {
+			return nil
+		}
+	}
+	ctx.Logger().Info("Deleting the unreferenced virtual service created by the operator",
+		logging.KeyAction, logging.ActionDelete)
+	err := istioClient.NetworkingV1alpha3().VirtualServices(target.Namespace).Delete(context.TODO(), target.Name,
+		metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &target.UID}})
+	if kerr.IsNotFound(err) || kerr.IsConflict(err) {
+		// already deleted, or recreated by someone else
+		return nil
+	}
+	return err
+}
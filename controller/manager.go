@@ -0,0 +1,59 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/monimesl/operator-helper/reconciler"
+	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// SetupWithManager registers the VirtualServiceMerge reconciler, its validating webhook when enabled,
+// and the health checks of the operator with the manager
+func SetupWithManager(mgr manager.Manager, opts Options, enableWebhook bool) error {
+	if opts.Recorder == nil {
+		opts.Recorder = mgr.GetEventRecorderFor("istio-virtualservice-merger")
+	}
+	if opts.APIReader == nil {
+		opts.APIReader = mgr.GetAPIReader()
+	}
+	ic, err := versionedclient.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return fmt.Errorf("istio client create error: %w", err)
+	}
+	if err = RegisterTargetIndex(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		return fmt.Errorf("target index error: %w", err)
+	}
+	if err = reconciler.Configure(mgr, &VirtualServicePatchReconciler{
+		IstioClient:    ic,
+		OldObjectCache: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		Options:        opts,
+	}); err != nil {
+		return fmt.Errorf("reconciler cfg error: %w", err)
+	}
+	if enableWebhook {
+		RegisterMergeValidator(mgr, opts)
+	}
+	if err = addHealthChecks(mgr, opts); err != nil {
+		return fmt.Errorf("health checks error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	driftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtualservicemerge_drift_corrections_total",
+		Help: "Number of times a patch missing from its target was merged again",
+	}, []string{"namespace", "name"})
+	unappliedMerges = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "virtualservicemerge_unapplied",
+		Help: "Number of merges in scope whose generation the operator failed to apply, as of the last readiness check",
+	})
+	targetQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "virtualservicemerge_target_queue_depth",
+		Help: "Number of reconciles writing to or waiting to write to a target",
+	}, []string{"namespace", "name"})
+	coalescedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtualservicemerge_coalesced_events_total",
+		Help: "Number of changes of merges held back to be written into a target along with other ones",
+	}, []string{"namespace", "name"})
+	batchedWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "virtualservicemerge_batched_writes_total",
+		Help: "Number of writes of a target merging the held back changes of its merges at once",
+	}, []string{"namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftCorrections, unappliedMerges, targetQueueDepth, coalescedEvents, batchedWrites)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"time"
+
+	configv1alpha1 "github.com/monimesl/istio-virtualservice-merger/api/config/v1alpha1"
+	"github.com/monimesl/operator-helper/reconciler"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Options configures how the patches are reconciled
+type Options struct {
+	// AllowUngovernedCrossNamespace admits merging into a target of another namespace
+	// which neither a MergePolicy nor an annotation of the target governs
+	AllowUngovernedCrossNamespace bool
+	// ResyncPeriod is how often an applied patch is compared with its target
+	// to repair manual edits; zero relies on the target events alone
+	ResyncPeriod time.Duration
+	// MaxConcurrentReconciles is the number of merges reconciled in parallel; one when zero
+	MaxConcurrentReconciles int
+	// RateLimiter delays the retries of the failed reconciles; the controller-runtime one when nil
+	RateLimiter workqueue.RateLimiter
+	// Debounce coalesces the changes of the merges of a target into one write
+	Debounce Debounce
+	// FeatureGates enables or disables the optional merge behaviors
+	FeatureGates FeatureGates
+	// DryRun computes and validates the merges of every patch without changing the targets
+	DryRun bool
+	// DiffConfigMap writes the full diff of the last change of every patch to a ConfigMap
+	// next to it, in addition to the bounded one in its status
+	DiffConfigMap bool
+	// RevisionHistoryLimit is the number of specs written to each target kept
+	// in ControllerRevisions for rolling back; zero disables the history
+	RevisionHistoryLimit int
+	// Scope restricts the merges and targets handled by the operator instance
+	Scope Scope
+	// APIReader reads the ControllerRevisions straight from the API server, so that they are not
+	// cached cluster wide; the reconcile client is used when nil
+	APIReader client.Reader
+	// Recorder receives the events about the patches; none are recorded when nil
+	Recorder record.EventRecorder
+	// ReadyOnConvergence reports the operator not ready while more than
+	// MaxUnappliedMerges of the merges in scope are not Applied for a reason of the operator
+	ReadyOnConvergence bool
+	MaxUnappliedMerges int
+	// TracerProvider traces the reconciles; the global OpenTelemetry provider is used when nil
+	TracerProvider trace.TracerProvider
+}
+
+// Configure sets the options the operator configuration holds
+func (o *Options) Configure(config *configv1alpha1.OperatorConfig) {
+	o.FeatureGates = config.FeatureGates
+	o.ResyncPeriod = config.Controller.ResyncPeriod.Duration
+	o.MaxConcurrentReconciles = config.Controller.MaxConcurrentReconciles
+	o.RateLimiter = config.RateLimiter()
+	o.Debounce = Debounce{
+		Window:   config.Controller.Debounce.Window.Duration,
+		MaxDelay: config.Controller.Debounce.MaxDelay.Duration,
+	}
+}
+
+// event records an event about the patch, annotated with the ID of the reconcile of the context
+func (o Options) event(ctx reconciler.Context, patch runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if o.Recorder == nil {
+		return
+	}
+	if id := reconcileID(ctx); id != "" {
+		o.Recorder.AnnotatedEventf(patch, map[string]string{AnnotationReconcileID: id}, eventType, reason, messageFmt, args...)
+		return
+	}
+	o.Recorder.Eventf(patch, eventType, reason, messageFmt, args...)
+}
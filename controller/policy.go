@@ -0,0 +1,70 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// admitPatch checks the patch against the merge policies of its target, or its annotation when
+// ungoverned, and the routes
+// of the other merges of the target, and returns the routes to drop from the patch
+func admitPatch(c client.Client, patch *v1alpha1.VirtualServiceMerge, opts Options) ([]v1alpha1.RouteViolation, error) {
+	policies := &v1alpha1.MergePolicyList{}
+	if err := c.List(context.TODO(), policies); err != nil {
+		return nil, err
+	}
+	var target metav1.Object
+	if key := patch.TargetKey(); key.Namespace != patch.Namespace {
+		// the target may allow the merges of other namespaces
+		vs := &istio.VirtualService{}
+		if err := c.Get(context.TODO(), key, vs); err == nil {
+			target = vs
+		} else if !kerr.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	violations, err := policies.Admit(patch, target, opts.AllowUngovernedCrossNamespace)
+	if err != nil {
+		return nil, err
+	}
+	merges := &v1alpha1.VirtualServiceMergeList{}
+	if err := c.List(context.TODO(), merges, client.MatchingFields{targetIndexKey: patch.TargetKey().String()}); err != nil {
+		return nil, err
+	}
+	if err := patch.CheckRouteOwners(merges.Items); err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
+
+func violationsMessage(violations []v1alpha1.RouteViolation) string {
+	reasons := make([]string, len(violations))
+	for i, v := range violations {
+		reasons[i] = v.String()
+	}
+	return fmt.Sprintf("The patch is merged into the target without the routes violating its policies: %s",
+		strings.Join(reasons, "; "))
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Merge policy", func() {
+	Context("method admitPatch(client, patch, opts)", func() {
+		var scheme *runtime.Scheme
+		var policy *v1alpha1.MergePolicy
+		var target *istio.VirtualService
+
+		newRoute := func(prefix, host string) *networkingv1alpha3.HTTPRoute {
+			return &networkingv1alpha3.HTTPRoute{
+				Match: []*networkingv1alpha3.HTTPMatchRequest{{
+					Uri: &networkingv1alpha3.StringMatch{
+						MatchType: &networkingv1alpha3.StringMatch_Prefix{Prefix: prefix},
+					},
+				}},
+				Route: []*networkingv1alpha3.HTTPRouteDestination{{
+					Destination: &networkingv1alpha3.Destination{Host: host},
+				}},
+			}
+		}
+
+		newPatch := func(namespace string, routes ...*networkingv1alpha3.HTTPRoute) *v1alpha1.VirtualServiceMerge {
+			return &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: "review-routes", Namespace: namespace},
+				Spec: v1alpha1.VirtualServiceMergeSpec{
+					Target: v1alpha1.Target{Name: "api-routes", Namespace: "gateway"},
+					Patch:  networkingv1alpha3.VirtualService{Http: routes},
+				},
+			}
+		}
+
+		admit := func(withPolicy, allowUngoverned bool, patch *v1alpha1.VirtualServiceMerge) ([]v1alpha1.RouteViolation, error) {
+			objects := []client.Object{}
+			if withPolicy {
+				objects = append(objects, policy)
+			}
+			if target != nil {
+				objects = append(objects, target)
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			return admitPatch(c, patch, Options{AllowUngovernedCrossNamespace: allowUngoverned})
+		}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+			Expect(istio.AddToScheme(scheme)).To(Succeed())
+			target = &istio.VirtualService{ObjectMeta: v1.ObjectMeta{Name: "api-routes", Namespace: "gateway"}}
+			policy = &v1alpha1.MergePolicy{
+				ObjectMeta: v1.ObjectMeta{Name: "api-routes"},
+				Spec: v1alpha1.MergePolicySpec{
+					Target: v1alpha1.Target{Name: "api-routes", Namespace: "gateway"},
+					Rules: []v1alpha1.MergePolicyRule{
+						{
+							Namespaces:       []string{"team-a"},
+							PathPrefixes:     []string{"/reviews"},
+							DestinationHosts: []string{"*.team-a.svc.cluster.local"},
+							DeniedFeatures:   []v1alpha1.RouteFeature{v1alpha1.FeatureMirror},
+						},
+						{Namespaces: []string{"gateway"}},
+					},
+				},
+			}
+		})
+
+		DescribeTable("admits or forbids the patch",
+			func(withPolicy, allowUngoverned bool, namespace, prefix, host string, admitted bool) {
+				_, err := admit(withPolicy, allowUngoverned, newPatch(namespace, newRoute(prefix, host)))
+				if admitted {
+					Expect(err).To(BeNil())
+				} else {
+					Expect(errors.Is(err, v1alpha1.ErrMergeForbidden)).To(BeTrue())
+				}
+			},
+			Entry("allowed namespace, path and host", true, false, "team-a", "/reviews/v1", "reviews.team-a.svc.cluster.local", true),
+			Entry("allowed namespace under a longer path", true, false, "team-a", "/reviewsadmin", "reviews.team-a.svc.cluster.local", false),
+			Entry("allowed namespace outside the paths", true, false, "team-a", "/products", "reviews.team-a.svc.cluster.local", false),
+			Entry("allowed namespace to a foreign host", true, false, "team-a", "/reviews", "reviews.team-b.svc.cluster.local", false),
+			Entry("namespace without restrictions", true, false, "gateway", "/products", "products", true),
+			Entry("namespace not in any rule", true, false, "team-b", "/reviews", "reviews.team-a.svc.cluster.local", false),
+			Entry("no policy", false, false, "team-b", "/reviews", "reviews", false),
+			Entry("no policy when allowed", false, true, "team-b", "/reviews", "reviews", true),
+			Entry("no policy in the target namespace", false, false, "gateway", "/reviews", "reviews", true),
+		)
+
+		DescribeTable("admits the namespaces allowed by the annotation of an ungoverned target",
+			func(allowed string, admitted bool) {
+				target.Annotations = map[string]string{v1alpha1.AnnotationAllowedNamespaces: allowed}
+				_, err := admit(false, false, newPatch("team-b", newRoute("/reviews", "reviews")))
+				if admitted {
+					Expect(err).To(BeNil())
+				} else {
+					Expect(errors.Is(err, v1alpha1.ErrMergeForbidden)).To(BeTrue())
+					Expect(err).To(MatchError(ContainSubstring(`no policy or annotation istiomerger.monime.sl/allowed-namespaces of the target allows namespace "team-b"`)))
+				}
+			},
+			Entry("listing the namespace", "team-a, team-b", true),
+			Entry("allowing every namespace", "*", true),
+			Entry("listing other namespaces", "team-a", false),
+			Entry("empty", "", false),
+		)
+
+		It("forbids the merges into a missing ungoverned target of another namespace", func() {
+			target = nil
+			_, err := admit(false, false, newPatch("team-b", newRoute("/reviews", "reviews")))
+			Expect(errors.Is(err, v1alpha1.ErrMergeForbidden)).To(BeTrue())
+		})
+
+		It("forbids the denied features", func() {
+			route := newRoute("/reviews", "reviews.team-a.svc.cluster.local")
+			route.Mirror = &networkingv1alpha3.Destination{Host: "shadow.team-a.svc.cluster.local"}
+			_, err := admit(true, false, newPatch("team-a", route))
+			Expect(err).To(MatchError(ContainSubstring(`uses the denied feature "mirror"`)))
+		})
+
+		It("drops the violating routes when enforcing with drop", func() {
+			policy.Spec.Enforcement = v1alpha1.EnforcementDrop
+			violations, err := admit(true, false, newPatch("team-a",
+				newRoute("/products", "products.team-a.svc.cluster.local"),
+				newRoute("/reviews", "reviews.team-a.svc.cluster.local")))
+			Expect(err).To(BeNil())
+			Expect(violations).To(HaveLen(1))
+			Expect(violations[0].Kind).To(Equal("http"))
+			Expect(violations[0].Index).To(Equal(0))
+		})
+
+		It("forbids the routes named after the routes of an older merge", func() {
+			owner := newPatch("team-b", newRoute("/ratings", "ratings.team-b.svc.cluster.local"))
+			owner.Name = "ratings-routes"
+			owner.CreationTimestamp = v1.Now()
+			owner.Spec.Patch.Http[0].Name = "ratings-0"
+			route := newRoute("/reviews", "reviews.team-a.svc.cluster.local")
+			route.Name = "ratings-0"
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(owner).Build()
+
+			_, err := admitPatch(c, newPatch("team-a", route), Options{AllowUngovernedCrossNamespace: true})
+
+			Expect(errors.Is(err, v1alpha1.ErrMergeForbidden)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("the route http/ratings-0 belongs to the merge team-b/ratings-routes")))
+		})
+	})
+
+	Context("method AdmitCreation(merge)", func() {
+		newMerge := func(namespace string, hosts, gateways []string) *v1alpha1.VirtualServiceMerge {
+			return &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: "review-routes", Namespace: namespace},
+				Spec: v1alpha1.VirtualServiceMergeSpec{
+					Target: v1alpha1.Target{Name: "api-routes", Namespace: "gateway",
+						CreateIfMissing: &v1alpha1.TargetTemplate{Hosts: hosts, Gateways: gateways}},
+				},
+			}
+		}
+		policies := &v1alpha1.MergePolicyList{Items: []v1alpha1.MergePolicy{{
+			ObjectMeta: v1.ObjectMeta{Name: "api-routes"},
+			Spec: v1alpha1.MergePolicySpec{
+				Target: v1alpha1.Target{Name: "api-routes", Namespace: "gateway"},
+				Rules: []v1alpha1.MergePolicyRule{
+					{
+						Namespaces: []string{"team-a"},
+						TargetCreation: &v1alpha1.TargetCreationRule{
+							Hosts:    []string{"*.team-a.example.com"},
+							Gateways: []string{"mesh"},
+						},
+					},
+					{Namespaces: []string{"team-b"}},
+				},
+			},
+		}}}
+
+		DescribeTable("admits or forbids creating the target",
+			func(namespace string, hosts, gateways []string, admitted bool) {
+				err := policies.AdmitCreation(newMerge(namespace, hosts, gateways))
+				if admitted {
+					Expect(err).To(BeNil())
+				} else {
+					Expect(errors.Is(err, v1alpha1.ErrMergeForbidden)).To(BeTrue())
+				}
+			},
+			Entry("in the target namespace", "gateway", []string{"api.example.com"}, []string{"public"}, true),
+			Entry("allowed hosts and gateways", "team-a", []string{"api.team-a.example.com"}, []string{"mesh"}, true),
+			Entry("a foreign host", "team-a", []string{"api.example.com"}, []string{"mesh"}, false),
+			Entry("a foreign gateway", "team-a", []string{"api.team-a.example.com"}, []string{"public"}, false),
+			Entry("a namespace allowed to merge only", "team-b", []string{"api.team-b.example.com"}, nil, false),
+			Entry("a namespace not in any rule", "team-c", []string{"api.team-c.example.com"}, nil, false),
+		)
+	})
+})
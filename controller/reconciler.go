@@ -18,21 +18,37 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/go-logr/logr"
+	"github.com/gogo/protobuf/proto"
 	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
 	"github.com/monimesl/operator-helper/oputil"
 	"github.com/monimesl/operator-helper/reconciler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
 	versionedclient "istio.io/client-go/pkg/clientset/versioned"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	finalizerName = "istiomerger.monime.sl-finalizer"
+	// ReasonDriftCorrected is the reason of the event recorded when a patch is merged again into an edited target
+	ReasonDriftCorrected = "DriftCorrected"
+	// ReasonRouteConflict is the reason of the event recorded when a patch is forbidden for naming a route of another merge
+	ReasonRouteConflict = "RouteConflict"
 )
 
-func Reconcile(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, oldpatchref interface{}) error {
+func Reconcile(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, oldpatchref interface{}, opts Options) error {
+	ctx = withObserved(ctx, patch.Status)
 	if oldpatchref != nil {
 		oldpatch := oldpatchref.(*v1alpha1.VirtualServiceMerge)
 		// check if target is different
@@ -46,11 +62,16 @@ func Reconcile(ctx reconciler.Context, client versionedclient.Interface, patch *
 		}
 		if oldTargetName != newTargetName || oldTargetNamespace != newTargetNamespace {
 			// remove from this object
-			ctx.Logger().Info("Virtual service target changed. Removing patch from old target", "virtualservice", oldTargetNamespace+"/"+oldTargetName)
-			if err := updateTarget(ctx, client, oldpatch, true); err != nil {
+			oldCtx := withValues(ctx, logging.KeyTarget, oldTargetNamespace+"/"+oldTargetName)
+			oldCtx.Logger().Info("Virtual service target changed. Removing patch from old target",
+				logging.KeyAction, logging.ActionRemove)
+			if _, err := updateTarget(oldCtx, client, oldpatch, true, opts); err != nil {
 				if kerr.IsNotFound(err) {
 					// ignore if virtualservice is not found
-					ctx.Logger().Info("Virtual service not found. Nothing to sync.")
+					oldCtx.Logger().Info("Virtual service not found. Nothing to sync.")
+				} else if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+					// the patch was never merged into the old target
+					oldCtx.Logger().Info("Patch not allowed on the old target. Nothing to remove.", "reason", err.Error())
 				} else {
 					return err
 				}
@@ -58,18 +79,28 @@ func Reconcile(ctx reconciler.Context, client versionedclient.Interface, patch *
 		}
 	}
 
+	ctx = withValues(ctx, logging.KeyTarget, patch.TargetKey().String())
+	trace.SpanFromContext(requestContext(ctx)).SetAttributes(attribute.String(logging.KeyTarget, patch.TargetKey().String()))
 	if patch.DeletionTimestamp.IsZero() {
 		if !oputil.ContainsWithPrefix(patch.Finalizers, finalizerName) {
-			ctx.Logger().Info("Adding the finalizer to the patch",
-				"patch", patch.Name, "finalizer", finalizerName)
+			ctx.Logger().Info("Adding the finalizer to the patch", "finalizer", finalizerName)
 			patch.Finalizers = append(patch.Finalizers, finalizerName)
+			claimShard(patch, opts.Scope.Shard)
+			return ctx.Client().Update(context.TODO(), patch)
+		}
+		if claimShard(patch, opts.Scope.Shard) {
+			ctx.Logger().Info("Recording the shard handling the patch", "shard", opts.Scope.Shard)
 			return ctx.Client().Update(context.TODO(), patch)
 		}
 	} else if oputil.Contains(patch.Finalizers, finalizerName) {
-		if err := updateTarget(ctx, client, patch, true); err != nil {
+		ctx.Logger().Info("Removing the deleted patch from the target", logging.KeyAction, logging.ActionRemove)
+		if _, err := updateTarget(ctx, client, patch, true, opts); err != nil {
 			if kerr.IsNotFound(err) {
 				// ignore if virtualservice is not found
 				ctx.Logger().Info("Virtual service not found. Nothing to sync.")
+			} else if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+				// the patch was never merged into the target
+				ctx.Logger().Info("Patch not allowed on the target. Nothing to remove.", "reason", err.Error())
 			} else {
 				return err
 			}
@@ -80,49 +111,330 @@ func Reconcile(ctx reconciler.Context, client versionedclient.Interface, patch *
 		}
 		return nil
 	}
-	if patch.ResourceVersion != patch.Status.HandledRevision {
-		if err := updateTarget(ctx, client, patch, false); err != nil {
-			if kerr.IsNotFound(err) {
-				// ignore if virtualservice is not found
-				ctx.Logger().Info("Virtual service not found. Nothing to sync.")
-			} else {
-				return err
-			}
+	if err := patch.Spec.Target.Validate(); err != nil {
+		return fmt.Errorf("virtualservicepatch.Reconcile: %w", err)
+	}
+	violations, err := admitPatch(ctx.Client(), patch, opts)
+	if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+		return forbidPatch(ctx, client, patch, err, opts)
+	} else if err != nil {
+		return err
+	}
+	target, err := getTarget(ctx, client, patch)
+	if kerr.IsNotFound(err) && patch.Spec.Target.CreateIfMissing != nil && !isDryRun(patch, opts) &&
+		opts.FeatureGates.Enabled(FeatureTargetCreation) {
+		target, err = createTarget(ctx, client, patch, opts)
+	}
+	if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+		// the patch may not create its target
+		return forbidPatch(ctx, client, patch, err, opts)
+	}
+	if kerr.IsNotFound(err) {
+		// the patch is merged again once the target is (re)created
+		ctx.Logger().Info("Virtual service not found. Waiting for it to be created.",
+			logging.KeyAction, logging.ActionSkip)
+		meta.SetStatusCondition(&patch.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionApplied,
+			Status:             metav1.ConditionFalse,
+			Reason:             v1alpha1.ReasonTargetMissing,
+			Message:            fmt.Sprintf("The target %s does not exist", patch.TargetKey()),
+			ObservedGeneration: patch.Generation,
+		})
+		patch.Status.Target = nil
+		return updateStatus(ctx, patch)
+	} else if err != nil {
+		return err
+	}
+	if revision, pinned := target.Annotations[v1alpha1.AnnotationPinnedRevision]; pinned {
+		return pausePatch(ctx, patch, revision)
+	}
+	condition := appliedCondition(patch, violations)
+	_, span := startSpan(ctx, "Merge", attribute.String(logging.KeyAction, logging.ActionMerge))
+	desired, report, err := mergedTarget(ctx.Logger(), patch, target, violations, false)
+	setReportAttributes(span, report)
+	endSpan(span, err)
+	if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+		for _, conflict := range report.Conflicts {
+			ctx.Logger().Info("Patch route owned by another merge",
+				logging.KeyRoute, conflict.Route, "owner", conflict.Sources[0])
+			opts.event(ctx, patch, corev1.EventTypeWarning, ReasonRouteConflict,
+				"The route %s belongs to %s", conflict.Route, conflict.Sources[0])
 		}
-		patch.Status.HandledRevision = patch.ResourceVersion
-		if err := ctx.Client().Status().Update(context.TODO(), patch); err != nil {
-			return fmt.Errorf("VirtualServiceMerge object (%s) status update error: %w", patch.Name, err)
+		return forbidPatch(ctx, client, patch, err, opts)
+	} else if err != nil {
+		return err
+	}
+	if isDryRun(patch, opts) {
+		return dryRunTarget(ctx, client, patch, target, desired, violations, opts)
+	}
+	if patch.Delegates() {
+		// the delegate exists before the target delegates to it
+		if err := syncDelegate(ctx, client, patch, violations); errors.Is(err, errDelegateTaken) {
+			return delegateTaken(ctx, patch, err)
+		} else if err != nil {
+			return err
 		}
+	}
+	_, remerge := patch.Annotations[v1alpha1.AnnotationRemerge]
+	applied := isApplied(patch, target, condition) && !remerge
+	if applied && isMerged(desired, target) {
 		return nil
 	}
+	if applied && !opts.FeatureGates.Enabled(FeatureDriftCorrection) {
+		ctx.Logger().Info("Patch drifted from the target. Drift correction disabled.",
+			logging.KeyAction, logging.ActionSkip)
+		return nil
+	}
+	if applied {
+		// the target was edited since the patch was merged into it
+		ctx.Logger().Info("Patch drifted from the target. Merging it again.",
+			logging.KeyAction, logging.ActionMerge)
+		opts.event(ctx, patch, corev1.EventTypeNormal, ReasonDriftCorrected,
+			"Merged the patch again into the edited target %s", patch.TargetKey())
+		driftCorrections.WithLabelValues(patch.Namespace, patch.Name).Inc()
+	} else if len(violations) > 0 {
+		ctx.Logger().Info("Dropped the patch routes violating the target policies", "routes", len(violations))
+	}
+	ctx.Logger().Info("Merging the patch into the target", logging.KeyAction, logging.ActionMerge)
+	spanCtx, span := startSpan(ctx, "UpdateTarget", attribute.String(logging.KeyAction, logging.ActionMerge))
+	updated, err := client.NetworkingV1alpha3().VirtualServices(desired.Namespace).
+		Update(requestContext(spanCtx), desired, metav1.UpdateOptions{})
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	if remerge {
+		delete(patch.Annotations, v1alpha1.AnnotationRemerge)
+		if err := ctx.Client().Update(context.TODO(), patch); err != nil {
+			return fmt.Errorf("VirtualServiceMerge object (%s) update error: %w", patch.Name, err)
+		}
+	}
+	if !patch.Delegates() && delegatesTo(target, patch) {
+		if err := releaseDelegate(ctx, client, patch); err != nil {
+			return err
+		}
+	}
+	recordDiff(ctx, patch, target, desired, opts)
+	recordRevision(ctx, updated, opts)
+	meta.SetStatusCondition(&patch.Status.Conditions, condition)
+	patch.Status.DryRunDiff = ""
+	patch.Status.Target = &v1alpha1.TargetStatus{
+		Namespace:  updated.Namespace,
+		Name:       updated.Name,
+		UID:        updated.UID,
+		Generation: updated.Generation,
+	}
+	return updateStatus(ctx, patch)
+}
+
+// isMerged checks if the target already holds the patch routes and their provenance
+func isMerged(desired, target *istio.VirtualService) bool {
+	return proto.Equal(&desired.Spec, &target.Spec) &&
+		desired.Annotations[v1alpha1.AnnotationRouteSources] == target.Annotations[v1alpha1.AnnotationRouteSources] &&
+		desired.Labels[v1alpha1.LabelMergeCount] == target.Labels[v1alpha1.LabelMergeCount]
+}
+
+// pausePatch records that the patch waits for its target to be unpinned
+func pausePatch(ctx reconciler.Context, patch *v1alpha1.VirtualServiceMerge, revision string) error {
+	message := fmt.Sprintf("The target is pinned to the revision %s", revision)
+	current := meta.FindStatusCondition(patch.Status.Conditions, v1alpha1.ConditionApplied)
+	if current != nil && current.Reason == v1alpha1.ReasonPaused && current.Message == message &&
+		patch.Status.ObservedGeneration == patch.Generation {
+		return nil
+	}
+	ctx.Logger().Info("Target pinned to a revision. Pausing the patch.",
+		logging.KeyAction, logging.ActionSkip, "revision", revision)
+	meta.SetStatusCondition(&patch.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1alpha1.ReasonPaused,
+		Message:            message,
+		ObservedGeneration: patch.Generation,
+	})
+	return updateStatus(ctx, patch)
+}
+
+// delegateTaken records that the patch waits for the name of its delegate to be freed
+func delegateTaken(ctx reconciler.Context, patch *v1alpha1.VirtualServiceMerge, reason error) error {
+	ctx.Logger().Info("Delegate virtual service taken. Leaving the target unchanged.",
+		logging.KeyAction, logging.ActionSkip, "reason", reason.Error())
+	meta.SetStatusCondition(&patch.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1alpha1.ReasonDelegateTaken,
+		Message:            reason.Error(),
+		ObservedGeneration: patch.Generation,
+	})
+	return updateStatus(ctx, patch)
+}
+
+// isApplied checks if the patch was already merged into this very target with
+// the same outcome, i.e. neither the patch nor its policies changed since
+func isApplied(patch *v1alpha1.VirtualServiceMerge, target *istio.VirtualService, condition metav1.Condition) bool {
+	status := patch.Status.Target
+	if status == nil || patch.Status.ObservedGeneration != patch.Generation {
+		return false
+	}
+	if status.Namespace != target.Namespace || status.Name != target.Name || status.UID != target.UID {
+		return false
+	}
+	current := meta.FindStatusCondition(patch.Status.Conditions, v1alpha1.ConditionApplied)
+	return current != nil && current.Status == condition.Status &&
+		current.Reason == condition.Reason && current.Message == condition.Message
+}
+
+// appliedCondition is the Applied condition of a patch merged without the violating routes
+func appliedCondition(patch *v1alpha1.VirtualServiceMerge, violations []v1alpha1.RouteViolation) metav1.Condition {
+	if len(violations) > 0 {
+		return metav1.Condition{
+			Type:               v1alpha1.ConditionApplied,
+			Status:             metav1.ConditionTrue,
+			Reason:             v1alpha1.ReasonRoutesDropped,
+			Message:            violationsMessage(violations),
+			ObservedGeneration: patch.Generation,
+		}
+	}
+	return metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             v1alpha1.ReasonApplied,
+		Message:            "The patch is merged into the target",
+		ObservedGeneration: patch.Generation,
+	}
+}
+
+func updateStatus(ctx reconciler.Context, patch *v1alpha1.VirtualServiceMerge) error {
+	patch.Status.ObservedGeneration = patch.Generation
+	patch.Status.ReconcileID = reconcileID(ctx)
+	if observed := asCorrelated(ctx).observed; observed != nil {
+		unchanged := patch.Status.DeepCopy()
+		unchanged.ReconcileID = observed.ReconcileID
+		if equality.Semantic.DeepEqual(unchanged, observed) {
+			// a reconcile which changes nothing keeps the status, and does not trigger another one
+			patch.Status.ReconcileID = observed.ReconcileID
+		}
+	}
+	if err := ctx.Client().Status().Update(context.TODO(), patch); err != nil {
+		return fmt.Errorf("VirtualServiceMerge object (%s) status update error: %w", patch.Name, err)
+	}
 	return nil
 }
 
-func updateTarget(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, remove bool) error {
+// forbidPatch withdraws the forbidden patch from its target and records why in its status
+func forbidPatch(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, reason error, opts Options) error {
+	if err := withdrawForbidden(ctx, client, patch, reason, opts); err != nil {
+		return err
+	}
+	patch.Status.Target = nil
+	return updateStatus(ctx, patch)
+}
+
+// withdrawForbidden removes the routes of a previously applied patch
+// which the target merge policies no longer admit and records why
+func withdrawForbidden(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, reason error, opts Options) error {
+	ctx.Logger().Info("Patch not allowed on the target", "reason", reason.Error())
+	if meta.IsStatusConditionTrue(patch.Status.Conditions, v1alpha1.ConditionApplied) {
+		ctx.Logger().Info("Removing the previously applied patch from the target", logging.KeyAction, logging.ActionRemove)
+		if _, err := updateTarget(ctx, client, patch, true, opts); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	meta.SetStatusCondition(&patch.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1alpha1.ReasonForbidden,
+		Message:            reason.Error(),
+		ObservedGeneration: patch.Generation,
+	})
+	return nil
+}
+
+// updateTarget adds or removes the patch routes admitted by the target policies
+// and returns the routes the policies dropped from the patch
+func updateTarget(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge, remove bool, opts Options) ([]v1alpha1.RouteViolation, error) {
 	if err := patch.Spec.Target.Validate(); err != nil {
-		return fmt.Errorf("virtualservicepatch.Reconcile: %w", err)
+		return nil, fmt.Errorf("virtualservicepatch.Reconcile: %w", err)
 	}
-	targetName, targetNamespace := patch.Spec.Target.Name, patch.Spec.Target.Namespace
-	if targetNamespace == "" {
-		targetNamespace = patch.Namespace
+	violations, err := admitPatch(ctx.Client(), patch, opts)
+	if err != nil {
+		// a patch merged before its policy changed is still allowed to clean up after itself
+		if !remove || !errors.Is(err, v1alpha1.ErrMergeForbidden) ||
+			!meta.IsStatusConditionTrue(patch.Status.Conditions, v1alpha1.ConditionApplied) {
+			return nil, err
+		}
 	}
-	target, err := client.NetworkingV1alpha3().VirtualServices(targetNamespace).
-		Get(context.TODO(), targetName, metav1.GetOptions{})
+	target, err := getTarget(ctx, client, patch)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if revision, pinned := target.Annotations[v1alpha1.AnnotationPinnedRevision]; pinned {
+		ctx.Logger().Info("Target pinned to a revision. Leaving it unchanged.",
+			logging.KeyAction, logging.ActionSkip, "revision", revision)
+		return violations, nil
+	}
+	action := logging.ActionMerge
 	if remove {
-		patch.RemoveTcpRoutes(target)
-		patch.RemoveTlsRoutes(target)
-		patch.RemoveHttpRoutes(ctx, target)
+		action = logging.ActionRemove
+	}
+	_, span := startSpan(ctx, "Merge", attribute.String(logging.KeyAction, action))
+	merged, report, err := mergedTarget(ctx.Logger(), patch, target, violations, remove)
+	setReportAttributes(span, report)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	spanCtx, span := startSpan(ctx, "UpdateTarget", attribute.String(logging.KeyAction, action),
+		attribute.Bool("dryRun", isDryRun(patch, opts)))
+	updated, err := client.NetworkingV1alpha3().VirtualServices(target.Namespace).
+		Update(requestContext(spanCtx), merged, updateOptions(patch, opts))
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	if isDryRun(patch, opts) {
+		diff, _ := specDiff(target, merged)
+		ctx.Logger().Info("Dry run: the target is left unchanged", logging.KeyAction, logging.ActionSkip, "diff", diff)
 	} else {
-		patch.AddTcpRoutes(target)
-		patch.AddTlsRoutes(target)
-		patch.AddHttpRoutes(ctx, target)
+		recordRevision(ctx, updated, opts)
+		if remove && delegatesTo(target, patch) {
+			if err := releaseDelegate(ctx, client, patch); err != nil {
+				return nil, err
+			}
+		}
+		if remove && opts.FeatureGates.Enabled(FeatureManagedTargetDeletion) {
+			if err := deleteUnreferencedTarget(ctx, client, patch, merged); err != nil {
+				ctx.Logger().Error(err, "Failed to delete the unreferenced target", logging.KeyAction, logging.ActionDelete)
+			}
+		}
 	}
-	if _, err = client.NetworkingV1alpha3().VirtualServices(targetNamespace).
-		Update(context.TODO(), target, metav1.UpdateOptions{}); err != nil {
-		return err
+	return violations, nil
+}
+
+// getTarget fetches the target of the patch. A target being deleted is reported as not found.
+func getTarget(ctx reconciler.Context, client versionedclient.Interface, patch *v1alpha1.VirtualServiceMerge) (*istio.VirtualService, error) {
+	key := patch.TargetKey()
+	spanCtx, span := startSpan(ctx, "GetTarget")
+	target, err := client.NetworkingV1alpha3().VirtualServices(key.Namespace).
+		Get(requestContext(spanCtx), key.Name, metav1.GetOptions{})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if !target.DeletionTimestamp.IsZero() {
+		return nil, kerr.NewNotFound(istio.SchemeGroupVersion.WithResource("virtualservices").GroupResource(), key.Name)
+	}
+	return target, nil
+}
+
+// mergedTarget returns a copy of the target with the patch routes, less the violating ones, added or removed
+func mergedTarget(log logr.Logger, patch *v1alpha1.VirtualServiceMerge, target *istio.VirtualService,
+	violations []v1alpha1.RouteViolation, remove bool) (*istio.VirtualService, *merge.Report, error) {
+	result, report, err := patch.MergeInto(log, target, violations, remove)
+	if errors.Is(err, merge.ErrRouteConflict) {
+		// the routes of another merge are never taken over
+		return nil, report, fmt.Errorf("%w: %s", v1alpha1.ErrMergeForbidden, err)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return result, report, nil
 }
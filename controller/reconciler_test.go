@@ -0,0 +1,550 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+	msvergealpha1 "github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/tests/mocks"
+	. "github.com/onsi/ginkgo/v2"
+
+	. "github.com/onsi/gomega"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+var _ = Describe("Reconciler", func() {
+	Context("method Reconcile(ctx, client, patch)", func() {
+		var pwd string
+		var ctrl *gomock.Controller
+		var mock_clientset *mocks.MockInterface
+		var mock_reconciler_context *mocks.MockContext
+		var mock_logger *mocks.MockLogger
+		var mock_client *mocks.MockClient
+		var mock_network_client *mocks.MockNetworkingV1alpha3Interface
+		var mock_vs_interface *mocks.MockVirtualServiceInterface
+		var vs istio.VirtualService
+		var vsMerge msvergealpha1.VirtualServiceMerge
+
+		BeforeEach(func() {
+			ctrl = gomock.NewController(GinkgoT())
+			_, filename, _, _ := runtime.Caller(0)
+			pwd = filepath.Dir(filename)
+			mock_logger = mocks.NewMockLogger(ctrl)
+			mock_logger.EXPECT().WithValues(gomock.Any(), gomock.Any()).Return(mock_logger).AnyTimes()
+			mock_logger.EXPECT().V(gomock.Any()).Return(logr.Discard()).AnyTimes()
+			mock_clientset = mocks.NewMockInterface(ctrl)
+			mock_reconciler_context = mocks.NewMockContext(ctrl)
+			mock_client = mocks.NewMockClient(ctrl)
+			mock_network_client = mocks.NewMockNetworkingV1alpha3Interface(ctrl)
+			mock_vs_interface = mocks.NewMockVirtualServiceInterface(ctrl)
+
+			// load virtualservice
+			payload, err := os.ReadFile(pwd + "/../tests/data/vs.yaml")
+			if err != nil {
+				panic(err)
+			}
+			vs = istio.VirtualService{}
+			if jsonByte, err := yaml.YAMLToJSON(payload); err == nil {
+				if err := json.Unmarshal(jsonByte, &vs); err != nil {
+					panic(err)
+				}
+			}
+
+			// load virsutalservicemerge
+			payload, err = os.ReadFile(pwd + "/../tests/data/vs-merge-1.yaml")
+			if err != nil {
+				panic(err)
+			}
+			vsMerge = msvergealpha1.VirtualServiceMerge{}
+			if jsonByte, err := yaml.YAMLToJSON(payload); err == nil {
+				if err := json.Unmarshal(jsonByte, &vsMerge); err != nil {
+					panic(err)
+				}
+			}
+		})
+
+		// =================================================================================
+		DescribeTable("will not throw exception",
+			func(vsExists bool, e error) {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.ResourceVersion = "1"
+
+				// setup expectations
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Status().Return(mock_client)
+
+				// expect vs update
+				if vsExists {
+					mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, e)
+					mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+					mock_logger.EXPECT().Info("Merging the patch into the target", gomock.Any(), gomock.Any())
+				} else {
+					mock_logger.EXPECT().Info("Virtual service not found. Waiting for it to be created.",
+						gomock.Any(), gomock.Any())
+					mock_vs_interface.EXPECT().
+						Get(gomock.Any(), gomock.Any(), gomock.Any()).
+						Return(&vs, e)
+				}
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				// mock_clientset.EXPECT().Logger()
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+			},
+			Entry("if VirtualService exists", true, nil),
+			Entry("if VirtualService does not exists", false, kerr.NewNotFound(schema.GroupResource{}, "vs not found")),
+		)
+
+		// =================================================================================
+		DescribeTable("will merge the patch again only when the target changed",
+			func(uid types.UID, holdsPatch, merged, drifted bool) {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Generation = 2
+				vs.UID, vs.Generation = "target-uid", 5
+				if holdsPatch {
+					merged, _, err := mergedTarget(logr.Discard(), vsMerge.DeepCopy(), &vs, nil, false)
+					Expect(err).To(BeNil())
+					vs = *merged
+				}
+				vsMerge.Status = msvergealpha1.VirtualServicePatchStatus{
+					ObservedGeneration: 2,
+					Target: &msvergealpha1.TargetStatus{
+						Namespace: vs.Namespace, Name: vs.Name, UID: uid, Generation: 4,
+					},
+				}
+				meta.SetStatusCondition(&vsMerge.Status.Conditions, appliedCondition(&vsMerge, nil))
+				recorder := record.NewFakeRecorder(1)
+
+				// setup expectations
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_logger.EXPECT().Info(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+				written := &vs
+				if merged {
+					mock_client.EXPECT().Status().Return(mock_client)
+					mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+					mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any()).
+						DoAndReturn(func(_ context.Context, target *istio.VirtualService, _ v1.UpdateOptions) (*istio.VirtualService, error) {
+							written = target
+							return target, nil
+						})
+				}
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{Recorder: recorder})
+
+				Expect(err).To(BeNil())
+				Expect(vsMerge.Status.Target.UID).To(Equal(vs.UID))
+				if merged && !holdsPatch {
+					Expect(vsMerge.Status.LastDiff.Added).To(Equal([]string{"http/review-routes-0"}))
+				}
+				Expect(written.Labels).To(HaveKeyWithValue(msvergealpha1.LabelMergeCount, "1"))
+				Expect(written.Annotations[msvergealpha1.AnnotationRouteSources]).To(ContainSubstring(`"http/review-routes-0"`))
+				if drifted {
+					Expect(recorder.Events).To(Receive(ContainSubstring(ReasonDriftCorrected)))
+				} else {
+					Expect(recorder.Events).NotTo(Receive())
+				}
+			},
+			Entry("if the target holds the patch", types.UID("target-uid"), true, false, false),
+			Entry("if the target was recreated", types.UID("old-uid"), false, true, false),
+			Entry("if the patch was edited out of the target", types.UID("target-uid"), false, true, true),
+		)
+
+		It("will leave the edited target alone without drift correction",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Generation = 2
+				vs.UID = "target-uid"
+				vsMerge.Status = msvergealpha1.VirtualServicePatchStatus{
+					ObservedGeneration: 2,
+					Target:             &msvergealpha1.TargetStatus{Namespace: vs.Namespace, Name: vs.Name, UID: vs.UID},
+				}
+				meta.SetStatusCondition(&vsMerge.Status.Conditions, appliedCondition(&vsMerge, nil))
+				recorder := record.NewFakeRecorder(1)
+
+				// setup expectations: the target is read but never written
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_logger.EXPECT().Info("Patch drifted from the target. Drift correction disabled.", gomock.Any(), gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{
+					Recorder:     recorder,
+					FeatureGates: FeatureGates{FeatureDriftCorrection: false},
+				})
+
+				Expect(err).To(BeNil())
+				Expect(recorder.Events).NotTo(Receive())
+			})
+
+		It("will merge the patch again when asked to without drift correction",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Annotations = map[string]string{msvergealpha1.AnnotationRemerge: "2021-06-01T00:00:00Z"}
+				vsMerge.Generation = 2
+				vs.UID = "target-uid"
+				vsMerge.Status = msvergealpha1.VirtualServicePatchStatus{
+					ObservedGeneration: 2,
+					Target:             &msvergealpha1.TargetStatus{Namespace: vs.Namespace, Name: vs.Name, UID: vs.UID},
+				}
+				meta.SetStatusCondition(&vsMerge.Status.Conditions, appliedCondition(&vsMerge, nil))
+
+				// setup expectations: the target is written and the request removed from the patch
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any()).Times(2)
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_logger.EXPECT().Info("Merging the patch into the target", gomock.Any(), gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+				mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{
+					FeatureGates: FeatureGates{FeatureDriftCorrection: false},
+				})
+
+				Expect(err).To(BeNil())
+				Expect(vsMerge.Annotations).NotTo(HaveKey(msvergealpha1.AnnotationRemerge))
+			})
+
+		// =================================================================================
+		It("will only validate the merge in a dry run",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Spec.DryRun = true
+
+				// setup expectations
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+				mock_logger.EXPECT().Info("Dry run: the patch would change the target",
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, nil)
+				mock_vs_interface.EXPECT().
+					Update(gomock.Any(), gomock.Any(), v1.UpdateOptions{DryRun: []string{v1.DryRunAll}}).
+					Return(&vs, nil)
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+				Expect(vsMerge.Status.Target).To(BeNil())
+				Expect(vsMerge.Status.DryRunDiff).To(ContainSubstring("+  name: review-routes-0"))
+				Expect(meta.FindStatusCondition(vsMerge.Status.Conditions, msvergealpha1.ConditionApplied).Reason).
+					To(Equal(msvergealpha1.ReasonDryRun))
+			},
+		)
+
+		// =================================================================================
+		It("will forbid the merge into an ungoverned target of another namespace",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Namespace = "team-a"
+				vsMerge.Spec.Target.Namespace = "gateway"
+
+				// setup expectations
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Get(gomock.Any(), vsMerge.TargetKey(), gomock.Any())
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+				mock_logger.EXPECT().Info("Patch not allowed on the target", gomock.Any(), gomock.Any())
+
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+				condition := meta.FindStatusCondition(vsMerge.Status.Conditions, msvergealpha1.ConditionApplied)
+				Expect(condition.Status).To(Equal(v1.ConditionFalse))
+				Expect(condition.Reason).To(Equal(msvergealpha1.ReasonForbidden))
+				Expect(condition.Message).To(ContainSubstring(`allows namespace "team-a" to merge into gateway/integration-test`))
+			},
+		)
+
+		// =================================================================================
+		It("will create the missing target from the template",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Spec.Target.CreateIfMissing = &msvergealpha1.TargetTemplate{Hosts: []string{"api.monime.sl"}}
+				recorder := record.NewFakeRecorder(1)
+
+				// setup expectations
+				var created, written *istio.VirtualService
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+				mock_logger.EXPECT().Info("Virtual service not found. Creating it from the template.",
+					gomock.Any(), gomock.Any())
+				mock_logger.EXPECT().Info("Merging the patch into the target", gomock.Any(), gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, kerr.NewNotFound(schema.GroupResource{}, "vs not found"))
+				mock_vs_interface.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, vs *istio.VirtualService, _ v1.CreateOptions) (*istio.VirtualService, error) {
+						created = vs.DeepCopy()
+						return vs, nil
+					})
+				mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, vs *istio.VirtualService, _ v1.UpdateOptions) (*istio.VirtualService, error) {
+						written = vs
+						return vs, nil
+					})
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				mock_logger.EXPECT().WithValues(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(mock_logger)
+				spans := tracetest.NewSpanRecorder()
+				ctx, span := correlate(mock_reconciler_context, context.Background(),
+					types.NamespacedName{Namespace: vsMerge.Namespace, Name: vsMerge.Name},
+					sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+				err := Reconcile(ctx, mock_clientset, &vsMerge, nil, Options{Recorder: recorder})
+				span.End()
+
+				Expect(err).To(BeNil())
+				var names []string
+				for _, ended := range spans.Ended() {
+					names = append(names, ended.Name())
+					Expect(ended.SpanContext().TraceID()).To(Equal(span.SpanContext().TraceID()))
+				}
+				Expect(names).To(Equal([]string{"GetTarget", "Merge", "UpdateTarget", "VirtualServiceMerge.Reconcile"}))
+				Expect(vsMerge.Status.ReconcileID).To(Equal(reconcileID(ctx)))
+				Expect(vsMerge.Status.ReconcileID).NotTo(BeEmpty())
+				Expect(created.Name).To(Equal(vsMerge.TargetKey().Name))
+				Expect(created.Labels).To(HaveKeyWithValue(msvergealpha1.LabelManaged, "true"))
+				Expect(created.Spec.Hosts).To(Equal([]string{"api.monime.sl"}))
+				Expect(written.Spec.Http).NotTo(BeEmpty())
+				Expect(recorder.Events).To(Receive(ContainSubstring("TargetCreated")))
+				Expect(meta.IsStatusConditionTrue(vsMerge.Status.Conditions, msvergealpha1.ConditionApplied)).To(BeTrue())
+			},
+		)
+
+		// =================================================================================
+		It("will delegate the patch routes in the Delegate mode",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Spec.Mode = msvergealpha1.MergeModeDelegate
+
+				// setup expectations
+				var delegate, written *istio.VirtualService
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+				mock_logger.EXPECT().Info("Creating the delegate virtual service",
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+				mock_logger.EXPECT().Info("Merging the patch into the target", gomock.Any(), gomock.Any())
+				mock_reconciler_context.EXPECT().SetOwnershipReference(&vsMerge, gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), vsMerge.TargetKey().Name, gomock.Any()).Return(&vs, nil)
+				mock_vs_interface.EXPECT().Get(gomock.Any(), "review-routes-delegate", gomock.Any()).
+					Return(nil, kerr.NewNotFound(schema.GroupResource{}, "vs not found"))
+				mock_vs_interface.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, vs *istio.VirtualService, _ v1.CreateOptions) (*istio.VirtualService, error) {
+						delegate = vs
+						return vs, nil
+					})
+				mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, vs *istio.VirtualService, _ v1.UpdateOptions) (*istio.VirtualService, error) {
+						written = vs
+						return vs, nil
+					})
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+				Expect(delegate.Spec.Hosts).To(BeEmpty())
+				Expect(delegate.Spec.Http).To(HaveLen(1))
+				Expect(delegate.Spec.Http[0].Name).To(Equal("review-routes-0"))
+				var root *networkingv1alpha3.HTTPRoute
+				for _, route := range written.Spec.Http {
+					Expect(route.Name).NotTo(Equal("review-routes-0"))
+					if route.Name == "review-routes-delegate-0" {
+						root = route
+					}
+				}
+				Expect(root).NotTo(BeNil())
+				Expect(root.Delegate.Name).To(Equal("review-routes-delegate"))
+				Expect(root.Match).To(Equal(delegate.Spec.Http[0].Match))
+			},
+		)
+
+		It("will leave a delegate controlled by something else alone",
+			func() {
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.Spec.Mode = msvergealpha1.MergeModeDelegate
+				vsMerge.UID = "merge-uid"
+				controller := true
+				taken := &istio.VirtualService{ObjectMeta: v1.ObjectMeta{
+					Name: "review-routes-delegate", Namespace: vsMerge.Namespace,
+					OwnerReferences: []v1.OwnerReference{{Kind: "VirtualServiceMerge", Name: "review-routes", UID: "other-uid", Controller: &controller}},
+				}}
+
+				// setup expectations: neither the delegate nor the target is written
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().Status().Return(mock_client)
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any())
+				mock_logger.EXPECT().Info("Delegate virtual service taken. Leaving the target unchanged.",
+					gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+				mock_reconciler_context.EXPECT().SetOwnershipReference(&vsMerge, gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), vsMerge.TargetKey().Name, gomock.Any()).Return(&vs, nil)
+				mock_vs_interface.EXPECT().Get(gomock.Any(), "review-routes-delegate", gomock.Any()).Return(taken, nil)
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+				Expect(meta.FindStatusCondition(vsMerge.Status.Conditions, msvergealpha1.ConditionApplied).Reason).
+					To(Equal(msvergealpha1.ReasonDelegateTaken))
+			},
+		)
+
+		// =================================================================================
+		It("will update finalizers on first run",
+			func() {
+				vsMerge.Finalizers = make([]string, 0)
+				vsMerge.ResourceVersion = "1"
+
+				// setup expectations
+				mock_logger.EXPECT().Info("Adding the finalizer to the patch", gomock.Any(), gomock.Any())
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any()).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client)
+
+				// mock_clientset.EXPECT().Logger()
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+			},
+		)
+
+		// =================================================================================
+		DescribeTable("will delete virtualservicemerge successfully",
+			func(vsExists bool, e error) {
+				vsMerge.Finalizers = make([]string, 0)
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.DeletionTimestamp = &v1.Time{
+					Time: time.Now(),
+				}
+				vsMerge.ResourceVersion = "1"
+
+				// setup expectations
+				mock_client.EXPECT().Update(gomock.Any(), &vsMerge, gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_logger.EXPECT().Info("Removing the deleted patch from the target", gomock.Any(), gomock.Any())
+				// expect vs update
+				if vsExists {
+					mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, e)
+					mock_vs_interface.EXPECT().Update(gomock.Any(), gomock.Any(), gomock.Any())
+				} else {
+					mock_logger.EXPECT().Info("Virtual service not found. Nothing to sync.").AnyTimes()
+					mock_vs_interface.EXPECT().
+						Get(gomock.Any(), gomock.Any(), gomock.Any()).
+						Return(&vs, e)
+				}
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+
+				// mock_clientset.EXPECT().Logger()
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+
+				Expect(err).To(BeNil())
+			},
+			Entry("if VirtualService exists", true, nil),
+			Entry("if VirtualService does not exists", false, kerr.NewNotFound(schema.GroupResource{}, "vs not found")),
+		)
+
+		// =================================================================================
+		DescribeTable("will panic with error",
+			func(e error) {
+				vsMerge.Finalizers = make([]string, 0)
+				vsMerge.Finalizers = append(vsMerge.Finalizers, "istiomerger.monime.sl-finalizer")
+				vsMerge.DeletionTimestamp = &v1.Time{
+					Time: time.Now(),
+				}
+				vsMerge.ResourceVersion = "1"
+
+				// setup expectations
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any()).AnyTimes()
+				mock_client.EXPECT().List(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mock_reconciler_context.EXPECT().Logger().Return(mock_logger).AnyTimes()
+				mock_reconciler_context.EXPECT().Client().Return(mock_client).AnyTimes()
+				mock_logger.EXPECT().Info("Removing the deleted patch from the target", gomock.Any(), gomock.Any())
+				mock_vs_interface.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(&vs, e)
+
+				mock_network_client.EXPECT().VirtualServices(gomock.Any()).Return(mock_vs_interface).AnyTimes()
+				mock_clientset.EXPECT().NetworkingV1alpha3().Return(mock_network_client).AnyTimes()
+
+				// mock_clientset.EXPECT().Logger()
+				err := Reconcile(mock_reconciler_context, mock_clientset, &vsMerge, nil, Options{})
+				Expect(err).To(Equal(e))
+			},
+			Entry("for 'bad request' error", kerr.NewBadRequest("bad request")),
+			Entry("for 'content expired' error", kerr.NewResourceExpired("expired")),
+			Entry("for 'internal server error'", kerr.NewInternalError(errors.New("server error"))),
+		)
+		// =================================================================================
+		// =================================================================================
+		AfterEach(func() {
+		})
+	})
+})
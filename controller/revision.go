@@ -0,0 +1,125 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/operator-helper/reconciler"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	appsv1 "k8s.io/api/apps/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// recordRevision keeps the spec written to the target in a ControllerRevision owned by it.
+// The history is for rolling back only so failures are just logged.
+func recordRevision(ctx reconciler.Context, target *istio.VirtualService, opts Options) {
+	if opts.RevisionHistoryLimit <= 0 {
+		return
+	}
+	reader := opts.APIReader
+	if reader == nil {
+		reader = ctx.Client()
+	}
+	if err := writeRevision(ctx.Client(), reader, target, opts.RevisionHistoryLimit); err != nil {
+		ctx.Logger().Error(err, "Failed to record the revision of the target",
+			logging.KeyTarget, target.Namespace+"/"+target.Name)
+	}
+}
+
+// writeRevision records the target spec as its latest revision, reading the
+// existing revisions with the reader and writing them with the client
+func writeRevision(c client.Client, reader client.Reader, target *istio.VirtualService, limit int) error {
+	key := types.NamespacedName{Namespace: target.Namespace, Name: target.Name}
+	merges := &v1alpha1.VirtualServiceMergeList{}
+	if err := c.List(context.TODO(), merges, client.MatchingFields{targetIndexKey: key.String()}); err != nil {
+		return err
+	}
+	data, err := json.Marshal(v1alpha1.NewTargetRevision(&target.Spec, merges.Items))
+	if err != nil {
+		return err
+	}
+	name, err := v1alpha1.RevisionName(target.Name, &target.Spec)
+	if err != nil {
+		return err
+	}
+	list := &appsv1.ControllerRevisionList{}
+	if err := reader.List(context.TODO(), list, client.InNamespace(target.Namespace),
+		client.MatchingLabels{v1alpha1.LabelRevisionTarget: target.Name}); err != nil {
+		return err
+	}
+	revisions := v1alpha1.TargetRevisions(list.Items, target)
+	next := int64(1)
+	if len(revisions) > 0 {
+		latest := revisions[len(revisions)-1]
+		if latest.Name == name {
+			return nil
+		}
+		next = latest.Revision + 1
+	}
+	revision := &appsv1.ControllerRevision{}
+	err = reader.Get(context.TODO(), types.NamespacedName{Namespace: target.Namespace, Name: name}, revision)
+	if kerr.IsNotFound(err) {
+		revision = &appsv1.ControllerRevision{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: target.Namespace,
+				Labels:    map[string]string{v1alpha1.LabelRevisionTarget: target.Name},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: istio.SchemeGroupVersion.String(),
+					Kind:       "VirtualService",
+					Name:       target.Name,
+					UID:        target.UID,
+				}},
+			},
+			Data:     runtime.RawExtension{Raw: data},
+			Revision: next,
+		}
+		if err := c.Create(context.TODO(), revision); err != nil {
+			return err
+		}
+		revisions = append(revisions, *revision)
+	} else if err != nil {
+		return err
+	} else {
+		// the spec was written before; it becomes the latest revision again
+		revision.Data, revision.Revision = runtime.RawExtension{Raw: data}, next
+		if err := c.Update(context.TODO(), revision); err != nil {
+			return err
+		}
+		for i := range revisions {
+			if revisions[i].Name == name {
+				revisions = append(revisions[:i], revisions[i+1:]...)
+				break
+			}
+		}
+		revisions = append(revisions, *revision)
+	}
+	for i := 0; i < len(revisions)-limit; i++ {
+		if err := c.Delete(context.TODO(), &revisions[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
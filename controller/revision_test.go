@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Revision history", func() {
+	Context("method writeRevision(client, reader, target, limit)", func() {
+		It("keeps the latest specs written to the target", func() {
+			scheme := runtime.NewScheme()
+			Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+			Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+			c := fake.NewClientBuilder().WithScheme(scheme).Build()
+			target := &istio.VirtualService{ObjectMeta: v1.ObjectMeta{Name: "api-routes", Namespace: "gateway", UID: "target-uid"}}
+
+			for _, host := range []string{"a", "b", "c", "c", "a"} {
+				target.Spec = networkingv1alpha3.VirtualService{Hosts: []string{host}}
+				Expect(writeRevision(c, c, target, 2)).To(Succeed())
+			}
+
+			list := &appsv1.ControllerRevisionList{}
+			Expect(c.List(context.TODO(), list)).To(Succeed())
+			revisions := v1alpha1.TargetRevisions(list.Items, target)
+			Expect(revisions).To(HaveLen(2))
+			hosts := make([]string, len(revisions))
+			for i := range revisions {
+				content, err := v1alpha1.DecodeTargetRevision(&revisions[i])
+				Expect(err).To(BeNil())
+				hosts[i] = content.Spec.Hosts[0]
+			}
+			Expect(hosts).To(Equal([]string{"c", "a"}))
+			Expect(revisions[1].Revision).To(Equal(int64(4)))
+		})
+	})
+})
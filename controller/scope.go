@@ -0,0 +1,87 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ShardLabel records on a VirtualServiceMerge the shard of the operator instance handling it
+const ShardLabel = "istiomerger.monime.sl/shard"
+
+// Scope restricts the slice of the cluster an operator instance handles,
+// so that several instances can share a cluster
+type Scope struct {
+	// Namespaces handled by the instance; empty means all of them
+	Namespaces []string
+	// Selector restricts the handled namespaces to the ones with matching labels; nil means all of them.
+	// A namespace missing from the cache is out of scope, so the cache may hold the selected namespaces only.
+	Selector labels.Selector
+	// Shard names the instance. It is recorded on the merges it handles,
+	// and merges recorded by another shard are left alone.
+	Shard string
+}
+
+// allowsNamespace checks if the merges of the namespace, or the targets in it, are handled
+func (s Scope) allowsNamespace(c client.Client, namespace string) (bool, error) {
+	if len(s.Namespaces) > 0 {
+		found := false
+		for _, ns := range s.Namespaces {
+			found = found || ns == namespace
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if s.Selector == nil || s.Selector.Empty() {
+		return true, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return s.Selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// allowsMerge checks if the merge and its target are both handled
+func (s Scope) allowsMerge(c client.Client, patch *v1alpha1.VirtualServiceMerge) (bool, error) {
+	if shard, ok := patch.Labels[ShardLabel]; ok && shard != s.Shard {
+		return false, nil
+	}
+	if ok, err := s.allowsNamespace(c, patch.Namespace); !ok || err != nil {
+		return false, err
+	}
+	return s.allowsNamespace(c, patch.TargetKey().Namespace)
+}
+
+// claimShard records the shard on the merge and reports if the merge changed
+func claimShard(patch *v1alpha1.VirtualServiceMerge, shard string) bool {
+	if shard == "" || patch.Labels[ShardLabel] == shard {
+		return false
+	}
+	if patch.Labels == nil {
+		patch.Labels = map[string]string{}
+	}
+	patch.Labels[ShardLabel] = shard
+	return true
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Scope", func() {
+	Context("method allowsMerge(client, patch)", func() {
+		newPatch := func(namespace, targetNamespace, shard string) *v1alpha1.VirtualServiceMerge {
+			patch := &v1alpha1.VirtualServiceMerge{
+				ObjectMeta: v1.ObjectMeta{Name: "review-routes", Namespace: namespace},
+				Spec: v1alpha1.VirtualServiceMergeSpec{
+					Target: v1alpha1.Target{Name: "api-routes", Namespace: targetNamespace},
+				},
+			}
+			if shard != "" {
+				patch.Labels = map[string]string{ShardLabel: shard}
+			}
+			return patch
+		}
+
+		DescribeTable("handles the merges in scope",
+			func(scope Scope, patch *v1alpha1.VirtualServiceMerge, allowed bool) {
+				scheme := runtime.NewScheme()
+				Expect(corev1.AddToScheme(scheme)).To(Succeed())
+				c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+					&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "team-a", Labels: map[string]string{"mesh": "on"}}},
+					&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "team-b"}},
+				).Build()
+				Expect(scope.allowsMerge(c, patch)).To(Equal(allowed))
+			},
+			Entry("without restrictions", Scope{}, newPatch("team-b", "", ""), true),
+			Entry("in a listed namespace", Scope{Namespaces: []string{"team-a"}}, newPatch("team-a", "", ""), true),
+			Entry("in an unlisted namespace", Scope{Namespaces: []string{"team-a"}}, newPatch("team-b", "", ""), false),
+			Entry("into an unlisted namespace", Scope{Namespaces: []string{"team-a"}}, newPatch("team-a", "team-b", ""), false),
+			Entry("in a selected namespace", Scope{Selector: labels.SelectorFromSet(labels.Set{"mesh": "on"})}, newPatch("team-a", "", ""), true),
+			Entry("in an unselected namespace", Scope{Selector: labels.SelectorFromSet(labels.Set{"mesh": "on"})}, newPatch("team-b", "", ""), false),
+			Entry("labelled with the shard", Scope{Shard: "one"}, newPatch("team-a", "", "one"), true),
+			Entry("labelled with another shard", Scope{Shard: "one"}, newPatch("team-a", "", "two"), false),
+		)
+	})
+})
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// targetLocks serializes the reconciles writing to the same target VirtualService,
+// so that the merges of different targets are still reconciled in parallel
+type targetLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*targetLock
+}
+
+type targetLock struct {
+	sync.Mutex
+	// depth is the number of reconciles holding or waiting for the lock
+	depth int
+}
+
+// lock locks the targets and returns the function unlocking them. The targets are
+// locked in order so that two reconciles locking the same ones never deadlock.
+func (l *targetLocks) lock(log logr.Logger, targets ...types.NamespacedName) (unlock func()) {
+	targets = uniqueTargets(targets)
+	held := make([]*targetLock, 0, len(targets))
+	for _, target := range targets {
+		lock := l.acquire(target)
+		if !lock.TryLock() {
+			log.V(1).Info("Waiting for another reconcile of the target", logging.KeyTarget, target.String())
+			lock.Lock()
+		}
+		held = append(held, lock)
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+			l.release(targets[i])
+		}
+	}
+}
+
+// acquire returns the lock of the target, counting the caller in its depth
+func (l *targetLocks) acquire(target types.NamespacedName) *targetLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = map[types.NamespacedName]*targetLock{}
+	}
+	lock, found := l.locks[target]
+	if !found {
+		lock = &targetLock{}
+		l.locks[target] = lock
+	}
+	lock.depth++
+	targetQueueDepth.WithLabelValues(target.Namespace, target.Name).Set(float64(lock.depth))
+	return lock
+}
+
+// release uncounts the caller from the depth of the target lock, forgetting it once unused
+func (l *targetLocks) release(target types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.locks[target]
+	if lock.depth--; lock.depth > 0 {
+		targetQueueDepth.WithLabelValues(target.Namespace, target.Name).Set(float64(lock.depth))
+		return
+	}
+	delete(l.locks, target)
+	targetQueueDepth.DeleteLabelValues(target.Namespace, target.Name)
+}
+
+func uniqueTargets(targets []types.NamespacedName) []types.NamespacedName {
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+	unique := targets[:0]
+	for i, target := range targets {
+		if i == 0 || target != targets[i-1] {
+			unique = append(unique, target)
+		}
+	}
+	return unique
+}
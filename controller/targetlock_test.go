@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("targetLocks", func() {
+	apiRoutes := types.NamespacedName{Namespace: "app-space", Name: "api-routes"}
+	webRoutes := types.NamespacedName{Namespace: "app-space", Name: "web-routes"}
+
+	It("serializes the reconciles of the same target", func() {
+		var locks targetLocks
+		unlock := locks.lock(logr.Discard(), apiRoutes)
+		Expect(testutil.ToFloat64(targetQueueDepth.WithLabelValues("app-space", "api-routes"))).To(Equal(1.0))
+
+		locked := make(chan func())
+		go func() {
+			defer GinkgoRecover()
+			locked <- locks.lock(logr.Discard(), webRoutes, apiRoutes)
+		}()
+		Consistently(locked, 50*time.Millisecond).ShouldNot(Receive())
+		Eventually(func() float64 {
+			return testutil.ToFloat64(targetQueueDepth.WithLabelValues("app-space", "api-routes"))
+		}).Should(Equal(2.0))
+
+		unlock()
+		var unlockOther func()
+		Eventually(locked).Should(Receive(&unlockOther))
+		unlockOther()
+		Expect(locks.locks).To(BeEmpty())
+		Expect(testutil.CollectAndCount(targetQueueDepth)).To(Equal(0))
+	})
+
+	It("reconciles different targets in parallel", func() {
+		var locks targetLocks
+		unlock := locks.lock(logr.Discard(), apiRoutes, apiRoutes)
+		defer unlock()
+		locked := make(chan func())
+		go func() {
+			locked <- locks.lock(logr.Discard(), webRoutes)
+		}()
+		var unlockOther func()
+		Eventually(locked).Should(Receive(&unlockOther))
+		unlockOther()
+	})
+})
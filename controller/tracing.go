@@ -0,0 +1,57 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"github.com/monimesl/operator-helper/reconciler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/monimesl/istio-virtualservice-merger/controller"
+
+// startSpan starts a child of the current span of the reconcile, current in the returned context
+func startSpan(ctx reconciler.Context, name string, attrs ...attribute.KeyValue) (reconciler.Context, trace.Span) {
+	correlated := *asCorrelated(ctx)
+	spanCtx, span := correlated.tracer.Start(correlated.context, name, trace.WithAttributes(attrs...))
+	correlated.context = spanCtx
+	return &correlated, span
+}
+
+// endSpan ends the span, recording the error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// setReportAttributes records the changes of a merge on its span
+func setReportAttributes(span trace.Span, report *merge.Report) {
+	if report == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.Int("routes.added", len(report.Added)),
+		attribute.Int("routes.replaced", len(report.Replaced)),
+		attribute.Int("routes.removed", len(report.Removed)),
+		attribute.Int("routes.conflicts", len(report.Conflicts)),
+	)
+}
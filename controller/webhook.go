@@ -0,0 +1,83 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// MergeValidatorPath is the path the VirtualServiceMerge validating webhook is served on
+const MergeValidatorPath = "/validate-istiomerger-monime-sl-v1alpha1-virtualservicemerge"
+
+// +kubebuilder:webhook:path=/validate-istiomerger-monime-sl-v1alpha1-virtualservicemerge,mutating=false,failurePolicy=fail,sideEffects=None,groups=istiomerger.monime.sl,resources=virtualservicemerges,verbs=create;update,versions=v1alpha1,name=vvirtualservicemerge.istiomerger.monime.sl,admissionReviewVersions=v1
+
+// MergeValidator rejects VirtualServiceMerges the merge policies of their target do not admit
+type MergeValidator struct {
+	Client  client.Client
+	Options Options
+	decoder *admission.Decoder
+}
+
+// RegisterMergeValidator serves the validator on the manager webhook server
+func RegisterMergeValidator(mgr manager.Manager, opts Options) {
+	mgr.GetWebhookServer().Register(MergeValidatorPath, &webhook.Admission{
+		Handler: &MergeValidator{Client: mgr.GetClient(), Options: opts},
+	})
+}
+
+func (v *MergeValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	patch := &v1alpha1.VirtualServiceMerge{}
+	if err := v.decoder.Decode(req, patch); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !patch.DeletionTimestamp.IsZero() {
+		// let the finalizer be removed
+		return admission.Allowed("")
+	}
+	if err := patch.Spec.Target.Validate(); err != nil {
+		return admission.Denied(err.Error())
+	}
+	violations, err := admitPatch(v.Client, patch, v.Options)
+	if err != nil {
+		if errors.Is(err, v1alpha1.ErrMergeForbidden) {
+			return admission.Denied(err.Error())
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(violations) > 0 {
+		return admission.Allowed("").WithWarnings(violationsMessage(violations))
+	}
+	return admission.Allowed("")
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *MergeValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cli holds the helpers shared by the command line tools
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+)
+
+// Command is a subcommand of a command line tool
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+// Main runs the subcommand named by the first program argument and exits
+func Main(program string, commands []Command) {
+	if len(os.Args) < 2 {
+		usage(program, commands)
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.Name == os.Args[1] {
+			if err := cmd.Run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s %s: %s\n", program, cmd.Name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage(program, commands)
+	os.Exit(2)
+}
+
+func usage(program string, commands []Command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", program)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.Name, cmd.Usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the command flags.\n", program)
+}
+
+// StringsFlag collects the values of a repeated flag
+type StringsFlag []string
+
+func (f *StringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *StringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// WriteObject prints the object in the yaml or json format
+func WriteObject(w io.Writer, obj interface{}, format string) error {
+	data, err := diff.Marshal(obj, format)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ParseInterspersed parses the flags wherever they are among the positional
+// arguments, which it returns
+func ParseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// The kinds of the documents read from the files
+const (
+	KindVirtualService      = "VirtualService"
+	KindVirtualServiceMerge = "VirtualServiceMerge"
+)
+
+// ReadDocuments decodes every document of the YAML or JSON file
+// and calls fn with the kind and the JSON encoding of the document
+func ReadDocuments(path string, fn func(kind string, data []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := utilyaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		raw := json.RawMessage{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		meta := struct {
+			Kind string `json:"kind"`
+		}{}
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(meta.Kind, raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+}
+
+// ReadVirtualService reads the single VirtualService of the file
+func ReadVirtualService(path, namespace string) (*istio.VirtualService, error) {
+	var vs *istio.VirtualService
+	err := ReadDocuments(path, func(kind string, data []byte) error {
+		if kind != KindVirtualService {
+			return fmt.Errorf("unexpected kind %q", kind)
+		}
+		if vs != nil {
+			return errors.New("more than one VirtualService")
+		}
+		vs = &istio.VirtualService{}
+		return json.Unmarshal(data, vs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if vs == nil {
+		return nil, fmt.Errorf("%s: no VirtualService", path)
+	}
+	if vs.Namespace == "" {
+		vs.Namespace = namespace
+	}
+	return vs, nil
+}
+
+// ReadMerges reads the VirtualServiceMerges of the files
+func ReadMerges(paths []string, namespace string) ([]*v1alpha1.VirtualServiceMerge, error) {
+	var merges []*v1alpha1.VirtualServiceMerge
+	for _, path := range paths {
+		err := ReadDocuments(path, func(kind string, data []byte) error {
+			if kind != KindVirtualServiceMerge {
+				return fmt.Errorf("unexpected kind %q", kind)
+			}
+			merge := &v1alpha1.VirtualServiceMerge{}
+			if err := json.Unmarshal(data, merge); err != nil {
+				return err
+			}
+			if merge.Namespace == "" {
+				merge.Namespace = namespace
+			}
+			merges = append(merges, merge)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merges, nil
+}
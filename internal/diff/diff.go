@@ -0,0 +1,96 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package diff renders objects as text and diffs them, for the operator
+// to record the changes it makes and for the command line tools to print them
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Marshal encodes the object in the yaml or json format
+func Marshal(obj interface{}, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(obj)
+	case "json":
+		data, err := json.MarshalIndent(obj, "", "  ")
+		return append(data, '\n'), err
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// Lines returns the line diff of a to b, prefixing the removed lines with "-", the
+// added ones with "+" and keeping the given number of unchanged lines around them.
+// It returns an empty string if a and b are equal.
+func Lines(a, b string, context int) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	var lines, removed, inserted []string
+	var changed []int
+	// the removed lines of a change come before the inserted ones
+	flush := func() {
+		for _, line := range append(removed, inserted...) {
+			changed = append(changed, len(lines))
+			lines = append(lines, line)
+		}
+		removed, inserted = nil, nil
+	}
+	i, j := 0, 0
+	for _, e := range edits(x, y) {
+		switch e {
+		case keep:
+			flush()
+			lines = append(lines, " "+x[i])
+			i++
+			j++
+		case remove:
+			removed = append(removed, "-"+x[i])
+			i++
+		case insert:
+			inserted = append(inserted, "+"+y[j])
+			j++
+		}
+	}
+	flush()
+	if len(changed) == 0 {
+		return ""
+	}
+	shown := make([]bool, len(lines))
+	for _, c := range changed {
+		for k := c - context; k <= c+context; k++ {
+			if k >= 0 && k < len(lines) {
+				shown[k] = true
+			}
+		}
+	}
+	var out strings.Builder
+	for k, line := range lines {
+		if !shown[k] {
+			continue
+		}
+		if k > 0 && !shown[k-1] {
+			out.WriteString("@@\n")
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.String()
+}
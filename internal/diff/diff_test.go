@@ -0,0 +1,114 @@
+package diff_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// patch rebuilds the sides of a diff printed without context limit
+func patch(lines string) (string, string) {
+	var a, b strings.Builder
+	for _, line := range strings.SplitAfter(lines, "\n") {
+		switch {
+		case strings.HasPrefix(line, " "):
+			a.WriteString(line[1:])
+			b.WriteString(line[1:])
+		case strings.HasPrefix(line, "-"):
+			a.WriteString(line[1:])
+		case strings.HasPrefix(line, "+"):
+			b.WriteString(line[1:])
+		}
+	}
+	return a.String(), b.String()
+}
+
+// changes counts the removed and added lines of a diff
+func changes(lines string) int {
+	count := 0
+	for _, line := range strings.Split(lines, "\n") {
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+") {
+			count++
+		}
+	}
+	return count
+}
+
+// commonLength returns the length of the longest common subsequence of the lines
+func commonLength(a, b []string) int {
+	previous, current := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				current[j+1] = previous[j] + 1
+			case previous[j+1] > current[j]:
+				current[j+1] = previous[j+1]
+			default:
+				current[j+1] = current[j]
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func randomLines(r *rand.Rand, count int) string {
+	var out strings.Builder
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&out, "%c\n", 'a'+r.Intn(4))
+	}
+	return out.String()
+}
+
+var _ = Describe("Lines", func() {
+	It("is empty for equal texts", func() {
+		Expect(diff.Lines("a\nb\n", "a\nb\n", 3)).To(BeEmpty())
+	})
+
+	DescribeTable("prints the changes with their context",
+		func(a, b string, context int, expected string) {
+			Expect(diff.Lines(a, b, context)).To(Equal(expected))
+		},
+		Entry("of a changed line", "a\nb\nc\n", "a\nx\nc\n", 1, " a\n-b\n+x\n c\n"),
+		Entry("of an added line", "a\nc\n", "a\nb\nc\n", 0, "@@\n+b\n"),
+		Entry("of a removed line", "a\nb\nc\n", "a\nc\n", 3, " a\n-b\n c\n"),
+		Entry("of the removals before the additions", "a\nb\nc\nd\n", "x\ny\nd\n", 0, "-a\n-b\n-c\n+x\n+y\n"),
+		Entry("of distant changes", "a\n1\n2\n3\n4\nb\n", "x\n1\n2\n3\n4\ny\n", 1,
+			"-a\n+x\n 1\n@@\n 4\n-b\n+y\n"),
+	)
+
+	It("removes and adds the fewest lines", func() {
+		r := rand.New(rand.NewSource(7))
+		for i := 0; i < 200; i++ {
+			a, b := randomLines(r, r.Intn(30)), randomLines(r, r.Intn(30))
+			lines := diff.Lines(a, b, len(a)+len(b))
+			if a == b {
+				Expect(lines).To(BeEmpty())
+				continue
+			}
+			x, y := strings.Split(strings.TrimSuffix(a, "\n"), "\n"), strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+			before, after := patch(lines)
+			Expect(before).To(Equal(strings.Join(x, "\n")+"\n"), "the diff of %q to %q", a, b)
+			Expect(after).To(Equal(strings.Join(y, "\n")+"\n"), "the diff of %q to %q", a, b)
+			Expect(changes(lines)).To(Equal(len(x)+len(y)-2*commonLength(x, y)), "the diff of %q to %q", a, b)
+		}
+	})
+
+	It("diffs large texts", func() {
+		var a, b strings.Builder
+		for i := 0; i < 100000; i++ {
+			fmt.Fprintf(&a, "line %d\n", i)
+			if i%1000 != 0 {
+				fmt.Fprintf(&b, "line %d\n", i)
+			}
+		}
+		lines := diff.Lines(a.String(), b.String(), 0)
+		Expect(changes(lines)).To(Equal(100))
+		Expect(lines).To(HavePrefix("-line 0\n@@\n-line 1000\n"))
+	})
+})
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diff
+
+// edit is a step of an edit script turning a sequence into another one
+type edit int
+
+const (
+	keep edit = iota
+	remove
+	insert
+)
+
+// edits returns a shortest edit script turning a into b. It runs the linear space variant of
+// the Myers diff: the middle snake of an optimal path splits the sequences in two halves,
+// which are compared in turn, so that the memory only grows with the length of the sequences.
+func edits(a, b []string) []edit {
+	size := len(a) + len(b) + 3
+	d := &differ{a: a, b: b, forward: make([]int, size), backward: make([]int, size), offset: size / 2}
+	d.compare(0, len(a), 0, len(b))
+	return d.script
+}
+
+type differ struct {
+	a, b []string
+	// forward and backward hold the furthest x reached on each diagonal, shifted by offset
+	forward, backward []int
+	offset            int
+	script            []edit
+}
+
+func (d *differ) compare(aLow, aHigh, bLow, bHigh int) {
+	for aLow < aHigh && bLow < bHigh && d.a[aLow] == d.b[bLow] {
+		d.script = append(d.script, keep)
+		aLow++
+		bLow++
+	}
+	suffix := 0
+	for aLow < aHigh && bLow < bHigh && d.a[aHigh-1] == d.b[bHigh-1] {
+		aHigh--
+		bHigh--
+		suffix++
+	}
+	switch {
+	case aLow == aHigh:
+		d.repeat(insert, bHigh-bLow)
+	case bLow == bHigh:
+		d.repeat(remove, aHigh-aLow)
+	default:
+		x, y := d.middleSnake(aLow, aHigh, bLow, bHigh)
+		d.compare(aLow, x, bLow, y)
+		d.compare(x, aHigh, y, bHigh)
+	}
+	d.repeat(keep, suffix)
+}
+
+func (d *differ) repeat(e edit, count int) {
+	for i := 0; i < count; i++ {
+		d.script = append(d.script, e)
+	}
+}
+
+// middleSnake returns a point of an optimal path through the sequences, other than their
+// ends, found where the paths searched from both ends meet. The diagonal k holds the points
+// whose x - y is k, x indexing a and y indexing b from the start forward, from the end backward.
+func (d *differ) middleSnake(aLow, aHigh, bLow, bHigh int) (int, int) {
+	n, m := aHigh-aLow, bHigh-bLow
+	delta := n - m
+	odd := delta%2 != 0
+	forward, backward, o := d.forward, d.backward, d.offset
+	forward[o+1], backward[o+1] = 0, 0
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			x := forward[o+k-1] + 1
+			if k == -step || k != step && forward[o+k-1] < forward[o+k+1] {
+				x = forward[o+k+1]
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLow+x] == d.b[bLow+y] {
+				x++
+				y++
+			}
+			forward[o+k] = x
+			if reverse := delta - k; odd && reverse >= 1-step && reverse <= step-1 && x+backward[o+reverse] >= n {
+				return aLow + x, bLow + y
+			}
+		}
+		for k := -step; k <= step; k += 2 {
+			x := backward[o+k-1] + 1
+			if k == -step || k != step && backward[o+k-1] < backward[o+k+1] {
+				x = backward[o+k+1]
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHigh-1-x] == d.b[bHigh-1-y] {
+				x++
+				y++
+			}
+			backward[o+k] = x
+			if ahead := delta - k; !odd && ahead >= -step && ahead <= step && x+forward[o+ahead] >= n {
+				return aHigh - x, bHigh - y
+			}
+		}
+	}
+	panic("diff: the paths from both ends never met")
+}
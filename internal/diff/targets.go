@@ -0,0 +1,157 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	"istio.io/api/networking/v1alpha3"
+)
+
+// Targets compares the routes of the target spec before and after a merge
+func Targets(before, after *v1alpha3.VirtualService) (*v1alpha1.TargetDiff, error) {
+	beforeIds, beforeIndex := routes(before)
+	afterIds, afterIndex := routes(after)
+	diff := &v1alpha1.TargetDiff{}
+	var keptAfter []string
+	for _, id := range afterIds {
+		old, ok := beforeIndex[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+			continue
+		}
+		keptAfter = append(keptAfter, id)
+		fields, err := changedFields(old, afterIndex[id])
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			diff.Replaced = append(diff.Replaced, v1alpha1.RouteChange{Route: id, Fields: fields})
+		}
+	}
+	var keptBefore []string
+	for _, id := range beforeIds {
+		if _, ok := afterIndex[id]; ok {
+			keptBefore = append(keptBefore, id)
+		} else {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	// the kept routes outside a longest common subsequence of both orders are the ones which moved
+	j := 0
+	for _, e := range edits(keptBefore, keptAfter) {
+		switch e {
+		case keep:
+			j++
+		case insert:
+			diff.Reordered = append(diff.Reordered, keptAfter[j])
+			j++
+		}
+	}
+	return diff, nil
+}
+
+// routes pairs the routes of the spec with their ids
+func routes(spec *v1alpha3.VirtualService) ([]string, map[string]interface{}) {
+	ids := merge.RouteIds(spec)
+	var all []interface{}
+	for _, route := range spec.Http {
+		all = append(all, route)
+	}
+	for _, route := range spec.Tcp {
+		all = append(all, route)
+	}
+	for _, route := range spec.Tls {
+		all = append(all, route)
+	}
+	index := make(map[string]interface{}, len(ids))
+	for i, id := range ids {
+		index[id] = all[i]
+	}
+	return ids, index
+}
+
+// changedFields returns the paths of the fields which differ in the json of the routes
+func changedFields(before, after interface{}) ([]string, error) {
+	var a, b interface{}
+	for _, v := range []struct {
+		route interface{}
+		out   *interface{}
+	}{{before, &a}, {after, &b}} {
+		data, err := json.Marshal(v.route)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, v.out); err != nil {
+			return nil, err
+		}
+	}
+	var fields []string
+	diffValues("", a, b, &fields)
+	return fields, nil
+}
+
+func diffValues(path string, a, b interface{}, fields *[]string) {
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			keys := map[string]bool{}
+			for k := range av {
+				keys[k] = true
+			}
+			for k := range bv {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				child := k
+				if path != "" {
+					child = path + "." + k
+				}
+				diffValues(child, av[k], bv[k], fields)
+			}
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				var ai, bi interface{}
+				if i < len(av) {
+					ai = av[i]
+				}
+				if i < len(bv) {
+					bi = bv[i]
+				}
+				diffValues(fmt.Sprintf("%s[%d]", path, i), ai, bi, fields)
+			}
+			return
+		}
+	}
+	*fields = append(*fields, path)
+}
@@ -0,0 +1,79 @@
+package diff_test
+
+import (
+	"fmt"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+)
+
+var _ = Describe("Targets", func() {
+	newRoute := func(name, host string) *networkingv1alpha3.HTTPRoute {
+		return &networkingv1alpha3.HTTPRoute{
+			Name: name,
+			Route: []*networkingv1alpha3.HTTPRouteDestination{{
+				Destination: &networkingv1alpha3.Destination{Host: host},
+			}},
+		}
+	}
+
+	It("reports the added, replaced, removed and reordered routes", func() {
+		before := &networkingv1alpha3.VirtualService{Http: []*networkingv1alpha3.HTTPRoute{
+			newRoute("reviews-2", "reviews"),
+			newRoute("ratings-1", "ratings"),
+			newRoute("mirror-1", "mirror"),
+			newRoute("products-1", "products"),
+			newRoute("default-0", "default"),
+		}}
+		after := &networkingv1alpha3.VirtualService{
+			Http: []*networkingv1alpha3.HTTPRoute{
+				newRoute("details-3", "details"),
+				newRoute("ratings-1", "ratings"),
+				newRoute("mirror-1", "mirror"),
+				newRoute("reviews-2", "reviews-v2"),
+				newRoute("default-0", "default"),
+			},
+			Tcp: []*networkingv1alpha3.TCPRoute{{
+				Match: []*networkingv1alpha3.L4MatchAttributes{{Port: 3306}},
+			}},
+		}
+		change, err := diff.Targets(before, after)
+		Expect(err).To(BeNil())
+		Expect(change.Added).To(Equal([]string{"http/details-3", "tcp/port-3306"}))
+		Expect(change.Replaced).To(Equal([]v1alpha1.RouteChange{
+			{Route: "http/reviews-2", Fields: []string{"route[0].destination.host"}},
+		}))
+		Expect(change.Removed).To(Equal([]string{"http/products-1"}))
+		Expect(change.Reordered).To(Equal([]string{"http/reviews-2"}))
+	})
+
+	It("numbers the unnamed routes among themselves", func() {
+		before := &networkingv1alpha3.VirtualService{Http: []*networkingv1alpha3.HTTPRoute{
+			newRoute("", "default"),
+		}}
+		after := &networkingv1alpha3.VirtualService{Http: []*networkingv1alpha3.HTTPRoute{
+			newRoute("reviews-0", "reviews"),
+			newRoute("", "default"),
+		}}
+		change, err := diff.Targets(before, after)
+		Expect(err).To(BeNil())
+		Expect(change.Added).To(Equal([]string{"http/reviews-0"}))
+		Expect(change.Replaced).To(BeEmpty())
+		Expect(change.Reordered).To(BeEmpty())
+	})
+
+	It("reports the fewest reordered routes of a long list", func() {
+		var before, after []*networkingv1alpha3.HTTPRoute
+		for i := 0; i < 5000; i++ {
+			before = append(before, newRoute(fmt.Sprintf("route-%d", i), "reviews"))
+		}
+		after = append(after, before[4999])
+		after = append(after, before[:4999]...)
+		change, err := diff.Targets(&networkingv1alpha3.VirtualService{Http: before}, &networkingv1alpha3.VirtualService{Http: after})
+		Expect(err).To(BeNil())
+		Expect(change.Reordered).To(Equal([]string{"http/route-4999"}))
+	})
+})
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package logging configures the operator logger and names the keys shared by its log lines
+package logging
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// The keys of the values logged with the messages
+const (
+	// KeyMerge is the namespace/name of the VirtualServiceMerge
+	KeyMerge = "merge"
+	// KeyTarget is the namespace/name of the target VirtualService
+	KeyTarget = "target"
+	// KeyRoute identifies a route, e.g. http/reviews-0
+	KeyRoute = "route"
+	// KeyReconcileID correlates the lines, events and status written by a reconcile
+	KeyReconcileID = "reconcileID"
+	// KeyAction is what the operator does to the target, one of the Action values
+	KeyAction = "action"
+	// KeyBatchedMerge is the namespace/name of a VirtualServiceMerge written along with the merge of the reconcile
+	KeyBatchedMerge = "batchedMerge"
+)
+
+// The values of KeyAction
+const (
+	ActionMerge    = "merge"
+	ActionRemove   = "remove"
+	ActionCreate   = "create"
+	ActionDelete   = "delete"
+	ActionSkip     = "skip"
+	ActionDelegate = "delegate"
+)
+
+// Formats of the log lines
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// New returns a logger of the level, i.e. debug, info, error or a verbosity
+// such as 2, writing its lines in the format
+func New(level, format string) (logr.Logger, error) {
+	enabler, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := []zap.Opts{zap.Level(enabler)}
+	switch format {
+	case FormatJSON:
+		opts = append(opts, zap.JSONEncoder())
+	case FormatConsole:
+		opts = append(opts, zap.ConsoleEncoder())
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatJSON, FormatConsole)
+	}
+	return zap.New(opts...), nil
+}
+
+func parseLevel(level string) (zapcore.Level, error) {
+	switch level {
+	case "debug":
+		return zapcore.DebugLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	}
+	verbosity, err := strconv.Atoi(level)
+	if err != nil || verbosity < 0 {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, error or a verbosity", level)
+	}
+	// the verbosity n of logr is the level -n of zap
+	return zapcore.Level(-verbosity), nil
+}
@@ -17,24 +17,29 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"strings"
 
+	configv1alpha1 "github.com/monimesl/istio-virtualservice-merger/api/config/v1alpha1"
 	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
 	"github.com/monimesl/istio-virtualservice-merger/controller"
-	"go.uber.org/zap/zapcore"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"github.com/monimesl/istio-virtualservice-merger/internal/tracing"
+	"go.opentelemetry.io/otel"
 	"istio.io/client-go/pkg/apis/networking/v1alpha3"
-	versionedclient "istio.io/client-go/pkg/clientset/versioned"
 
 	"github.com/monimesl/operator-helper/config"
-	"github.com/monimesl/operator-helper/reconciler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
-	"k8s.io/client-go/tools/cache"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	// +kubebuilder:scaffold:imports
 )
@@ -53,36 +58,113 @@ func init() {
 
 func main() {
 	var namespace string
+	var enableWebhook bool
+	var opts controllers.Options
+	var configFile string
+	operatorConfig := configv1alpha1.NewDefault()
+	operatorConfig.LeaderElection.Enabled = config.LeaderElectionEnabled()
+	operatorConfig.AddFlags(flag.CommandLine)
+	flag.StringVar(&configFile, "config", "", "Path of an OperatorConfig file; the flags set explicitly override it")
 	flag.StringVar(&namespace, "namespace", "istio-virtualservice-merger", "Select which namespace this controller is deployed")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false, "Serve the VirtualServiceMerge validating webhook")
+	flag.BoolVar(&opts.AllowUngovernedCrossNamespace, "allow-ungoverned-cross-namespace", false, "Allow merging into a target of another namespace which no MergePolicy or target annotation governs")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Report the changes the merges would make to their targets without applying them")
+	flag.BoolVar(&opts.DiffConfigMap, "diff-configmap", false, "Write the full diff of the last change of every merge to a ConfigMap named after it")
+	flag.IntVar(&opts.RevisionHistoryLimit, "revision-history-limit", 10, "Number of target specs kept in ControllerRevisions for rolling back; 0 disables the history")
+	var watchNamespaces, namespaceSelector string
+	flag.StringVar(&watchNamespaces, "watch-namespaces", strings.Join(config.NamespacesToWatch(), ","), "Comma separated namespaces whose merges and targets are handled; all of them when empty")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "", "Label selector restricting the namespaces whose merges and targets are handled; unlike -watch-namespaces, the merges and targets of the other namespaces are still watched")
+	flag.StringVar(&opts.Scope.Shard, "shard", "", "Name of this operator instance when several of them share the cluster; it does not reduce the watched objects")
+	flag.BoolVar(&opts.ReadyOnConvergence, "ready-on-convergence", false, "Report the operator not ready while it failed to apply more than -max-unapplied-merges merges")
+	flag.IntVar(&opts.MaxUnappliedMerges, "max-unapplied-merges", 0, "Number of merges failed to apply tolerated by -ready-on-convergence")
+	var logLevel, logFormat string
+	flag.StringVar(&logLevel, "log-level", "info", "Lowest level of the logged lines: debug, info, error or a verbosity such as 2")
+	flag.StringVar(&logFormat, "log-format", logging.FormatJSON, "Format of the logged lines: json or console")
+	var traceOpts tracing.Options
+	flag.StringVar(&traceOpts.Endpoint, "otlp-endpoint", "", "host:port of the OTLP/HTTP collector receiving the spans; tracing is disabled unless it or OTEL_EXPORTER_OTLP_ENDPOINT is set")
+	flag.BoolVar(&traceOpts.Insecure, "otlp-insecure", false, "Send the spans to the collector over plain HTTP")
+	flag.Float64Var(&traceOpts.SampleRatio, "trace-sample-ratio", 1, "Fraction of the reconciles traced")
 	flag.Parse()
+	if configFile != "" {
+		if err := operatorConfig.Load(configFile); err != nil {
+			log.Fatalf("invalid config: %s", err)
+		}
+		// the flags set explicitly win over the file
+		flag.Parse()
+	}
+	opts.Configure(operatorConfig)
+	if err := utilerrors.NewAggregate([]error{operatorConfig.Validate(), opts.FeatureGates.Validate()}); err != nil {
+		log.Fatalf("invalid config: %s", err)
+	}
+	if watchNamespaces != "" {
+		for _, ns := range strings.Split(watchNamespaces, ",") {
+			opts.Scope.Namespaces = append(opts.Scope.Namespaces, strings.TrimSpace(ns))
+		}
+	}
+	selector, err := labels.Parse(namespaceSelector)
+	if err != nil {
+		log.Fatalf("invalid namespace selector: %s", err)
+	}
+	opts.Scope.Selector = selector
 
 	// set logger
-	opts := zap.Options{
-		Development: true,
-		Encoder:     zapcore.NewJSONEncoder(zapcore.EncoderConfig{}),
+	logger, err := logging.New(logLevel, logFormat)
+	if err != nil {
+		log.Fatal(err)
 	}
+	ctrl.SetLogger(logger)
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	// set tracer
+	shutdownTracing := func() {}
+	if traceOpts.Enabled() {
+		provider, err := tracing.NewProvider(context.Background(), traceOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		shutdownTracing = func() {
+			// flush the last spans
+			if err := provider.Shutdown(context.Background()); err != nil {
+				log.Printf("tracer shutdown error: %s", err)
+			}
+		}
+		otel.SetTracerProvider(provider)
+		opts.TracerProvider = provider
+	}
 
 	// start manager
 	cfg, options := config.GetManagerParams(scheme,
 		namespace,
 		"istiomerger.monime.sl")
-	//set metrics server address & port
-	options.MetricsBindAddress = ":8080"
+	operatorConfig.ApplyTo(&options)
+	switch len(opts.Scope.Namespaces) {
+	case 0:
+		options.Namespace = ""
+	case 1:
+		options.Namespace = opts.Scope.Namespaces[0]
+	default:
+		options.NewCache = ctrlcache.MultiNamespacedCacheBuilder(opts.Scope.Namespaces)
+	}
+	if !selector.Empty() && options.NewCache == nil {
+		// only the selected namespaces are cached; the others are not found, hence out of scope.
+		// The merges and targets of every watched namespace are still cached.
+		options.NewCache = ctrlcache.BuilderWithOptions(ctrlcache.Options{
+			SelectorsByObject: ctrlcache.SelectorsByObject{&corev1.Namespace{}: {Label: selector}},
+		})
+	}
+	if opts.Scope.Shard != "" {
+		// every shard elects its own leader
+		options.LeaderElectionID += "." + opts.Scope.Shard
+	}
 	mgr, err := manager.New(cfg, options)
 	if err != nil {
 		log.Fatalf("manager create error: %s", err)
 	}
-	ic, err := versionedclient.NewForConfig(cfg)
-	if err != nil {
-		log.Fatalf("Failed to create istio client: %s", err)
-	}
-	if err = reconciler.Configure(mgr,
-		&controllers.VirtualServicePatchReconciler{IstioClient: ic, OldObjectCache: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})}); err != nil {
-		log.Fatalf("reconciler cfg error: %s", err)
+	if err = controllers.SetupWithManager(mgr, opts, enableWebhook); err != nil {
+		log.Fatal(err)
 	}
-	if err = mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	shutdownTracing()
+	if err != nil {
 		log.Fatalf("operator start error: %s", err)
 	}
 }
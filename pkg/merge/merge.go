@@ -0,0 +1,285 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package merge merges the routes of patches into a base Istio VirtualService. It works on the Istio
+// API types only, without any Kubernetes client, and never changes its arguments.
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"istio.io/api/networking/v1alpha3"
+)
+
+var (
+	// ErrNoBase is returned when there is no VirtualService to merge the patches into
+	ErrNoBase = errors.New("merge: no base VirtualService")
+	// ErrRouteConflict is returned when a patch would replace a route of another source
+	ErrRouteConflict = errors.New("merge: route owned by another source")
+)
+
+// Patch is a set of routes to merge into the base
+type Patch struct {
+	// Name is the prefix of the generated names of the http routes, i.e. the name of the VirtualServiceMerge
+	Name string
+	// Source identifies the patch in the report, e.g. its namespace/name
+	Source string
+	// Spec holds the routes of the patch
+	Spec *v1alpha3.VirtualService
+}
+
+// Options of a merge
+type Options struct {
+	// Remove removes the patch routes from the base instead of merging them
+	Remove bool
+	// Owners are the sources of the routes of the base, by route id. A patch replacing a route
+	// owned by another source fails the merge, and the routes of other sources are never removed.
+	Owners map[string]string
+	// Log receives the merge steps; nil discards them
+	Log logr.Logger
+}
+
+// Report lists the changes of a merge. The routes are identified by their kind and name,
+// e.g. http/reviews-0, and the tcp and tls routes by their ports, e.g. tcp/port-3306.
+type Report struct {
+	Added     []Change   `json:"added,omitempty"`
+	Replaced  []Change   `json:"replaced,omitempty"`
+	Removed   []Change   `json:"removed,omitempty"`
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+}
+
+// Change is a route changed by a patch
+type Change struct {
+	Route  string `json:"route"`
+	Source string `json:"source,omitempty"`
+}
+
+// Conflict is a route of a source which other sources tried to replace
+type Conflict struct {
+	Route   string   `json:"route"`
+	Sources []string `json:"sources"`
+}
+
+// Empty checks if the merge changed nothing
+func (r *Report) Empty() bool {
+	return len(r.Added) == 0 && len(r.Replaced) == 0 && len(r.Removed) == 0
+}
+
+// Merge returns a copy of the base with the routes of the patches merged in, or removed with
+// Options.Remove, in order. The http routes of a patch are named <patch name>-<precedence> unless
+// their name already ends with a precedence, and are sorted by decreasing precedence; a patch
+// route replaces the base route of the same name. A tcp or tls route replaces the first base
+// route sharing one of its ports, and is appended otherwise. A patch route replacing a route of
+// another source fails the merge with ErrRouteConflict, along with the report of the conflicts.
+func Merge(base *v1alpha3.VirtualService, patches []Patch, opts Options) (*v1alpha3.VirtualService, *Report, error) {
+	if base == nil {
+		return nil, nil, ErrNoBase
+	}
+	m := &merger{
+		log:    opts.Log,
+		result: base.DeepCopy(),
+		report: &Report{},
+		owners: map[string]string{},
+	}
+	if m.log == nil {
+		m.log = logr.Discard()
+	}
+	for id, source := range opts.Owners {
+		m.owners[id] = source
+	}
+	for i, patch := range patches {
+		if patch.Spec == nil {
+			return nil, nil, fmt.Errorf("merge: patch %d (%s) has no spec", i, patch.Source)
+		}
+		if opts.Remove {
+			m.remove(patch)
+		} else {
+			m.add(patch)
+		}
+	}
+	if len(m.report.Conflicts) > 0 {
+		routes := make([]string, len(m.report.Conflicts))
+		for i, conflict := range m.report.Conflicts {
+			routes[i] = fmt.Sprintf("%s of %s", conflict.Route, conflict.Sources[0])
+		}
+		return nil, m.report, fmt.Errorf("%w: %s", ErrRouteConflict, strings.Join(routes, ", "))
+	}
+	return m.result, m.report, nil
+}
+
+// MergeSource merges the patch of a source into the base, or removes it with Options.Remove, the way the
+// operator writes each merge into its target: the routes of the retired patch of the source, e.g. of the mode
+// it left, are removed first, and the routes of the other sources of Options.Owners are kept.
+func MergeSource(base *v1alpha3.VirtualService, patch, retired Patch, opts Options) (*v1alpha3.VirtualService, *Report, error) {
+	removal := opts
+	removal.Remove = true
+	spec, _, err := Merge(base, []Patch{retired}, removal)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Merge(spec, []Patch{patch}, opts)
+}
+
+type merger struct {
+	log    logr.Logger
+	result *v1alpha3.VirtualService
+	report *Report
+	owners map[string]string
+}
+
+func (m *merger) add(patch Patch) {
+	for _, route := range patch.Spec.Tcp {
+		i := indexOfTcpRoute(m.result.Tcp, route)
+		id := "tcp/" + l4Id(tcpPorts(route), unportedTcpRoutes(m.result.Tcp))
+		if i >= 0 {
+			if m.write(id, "tcp/"+PortsId(tcpPorts(m.result.Tcp[i])), patch.Source) {
+				m.result.Tcp[i] = route.DeepCopy()
+			}
+		} else {
+			m.write(id, "", patch.Source)
+			m.result.Tcp = append(m.result.Tcp, route.DeepCopy())
+		}
+	}
+	for _, route := range patch.Spec.Tls {
+		i := indexOfTlsRoute(m.result.Tls, route)
+		id := "tls/" + l4Id(tlsPorts(route), unportedTlsRoutes(m.result.Tls))
+		if i >= 0 {
+			if m.write(id, "tls/"+PortsId(tlsPorts(m.result.Tls[i])), patch.Source) {
+				m.result.Tls[i] = route.DeepCopy()
+			}
+		} else {
+			m.write(id, "", patch.Source)
+			m.result.Tls = append(m.result.Tls, route.DeepCopy())
+		}
+	}
+	routes := m.result.Http
+outer:
+	for _, route := range NameHttpRoutes(m.log, patch.Name, patch.Spec.Http) {
+		for i, current := range routes {
+			if current.Name == route.Name {
+				if m.write("http/"+route.Name, "http/"+route.Name, patch.Source) {
+					routes[i] = route
+				}
+				continue outer
+			}
+		}
+		// prepend so that the new route is above the "default", i.e.
+		// the route without a match already in the base
+		m.write("http/"+route.Name, "", patch.Source)
+		routes = append([]*v1alpha3.HTTPRoute{route}, routes...)
+	}
+	m.result.Http = sortHttpRoutes(m.log, routes)
+}
+
+func (m *merger) remove(patch Patch) {
+	for _, route := range patch.Spec.Tcp {
+		if i := indexOfTcpRoute(m.result.Tcp, route); i >= 0 && m.removes("tcp/"+PortsId(tcpPorts(m.result.Tcp[i])), patch.Source) {
+			m.removed("tcp/"+PortsId(tcpPorts(m.result.Tcp[i])), patch.Source)
+			m.result.Tcp = append(m.result.Tcp[:i:i], m.result.Tcp[i+1:]...)
+		}
+	}
+	for _, route := range patch.Spec.Tls {
+		if i := indexOfTlsRoute(m.result.Tls, route); i >= 0 && m.removes("tls/"+PortsId(tlsPorts(m.result.Tls[i])), patch.Source) {
+			m.removed("tls/"+PortsId(tlsPorts(m.result.Tls[i])), patch.Source)
+			m.result.Tls = append(m.result.Tls[:i:i], m.result.Tls[i+1:]...)
+		}
+	}
+	routes := m.result.Http
+outer:
+	for _, route := range NameHttpRoutes(m.log, patch.Name, patch.Spec.Http) {
+		for i, current := range routes {
+			if current.Name == route.Name {
+				if m.removes("http/"+route.Name, patch.Source) {
+					m.removed("http/"+route.Name, patch.Source)
+					routes = append(routes[:i:i], routes[i+1:]...)
+				}
+				continue outer
+			}
+		}
+	}
+	m.result.Http = sortHttpRoutes(m.log, routes)
+}
+
+// write records that the source added the route, or replaced the route of the replaced id,
+// and checks that it may: the route of another source is never replaced
+func (m *merger) write(id, replaced, source string) bool {
+	if owner, ok := m.owners[replaced]; ok && owner != source {
+		m.log.Info("Route owned by another source", logging.KeyRoute, replaced, "owner", owner, "source", source)
+		m.conflict(replaced, owner, source)
+		return false
+	}
+	delete(m.owners, replaced)
+	m.owners[id] = source
+	change := Change{Route: id, Source: source}
+	if replaced == "" {
+		m.report.Added = append(m.report.Added, change)
+		return true
+	}
+	for i := range m.report.Added {
+		if m.report.Added[i].Route == replaced {
+			// added earlier in this merge
+			m.report.Added[i].Route = id
+			m.report.Added[i].Source = source
+			return true
+		}
+	}
+	for i := range m.report.Replaced {
+		if m.report.Replaced[i].Route == replaced {
+			m.report.Replaced[i].Route = id
+			m.report.Replaced[i].Source = source
+			return true
+		}
+	}
+	m.report.Replaced = append(m.report.Replaced, change)
+	return true
+}
+
+// removes checks if the source may remove the route, i.e. the route is not owned by another source
+func (m *merger) removes(id, source string) bool {
+	if owner, ok := m.owners[id]; ok && owner != source {
+		m.log.V(1).Info("Leaving the route of another source", logging.KeyRoute, id, "owner", owner, "source", source)
+		return false
+	}
+	return true
+}
+
+func (m *merger) removed(id, source string) {
+	delete(m.owners, id)
+	m.report.Removed = append(m.report.Removed, Change{Route: id, Source: source})
+}
+
+func (m *merger) conflict(id, owner, source string) {
+	for i := range m.report.Conflicts {
+		if m.report.Conflicts[i].Route == id {
+			m.report.Conflicts[i].Sources = append(m.report.Conflicts[i].Sources, source)
+			return
+		}
+	}
+	m.report.Conflicts = append(m.report.Conflicts, Conflict{Route: id, Sources: []string{owner, source}})
+}
+
+// l4Id identifies a tcp or tls route by its ports. A route without ports is never replaced but
+// appended, so it is identified by the number of routes without ports before it.
+func l4Id(ports []uint32, unported int) string {
+	if id := PortsId(ports); id != "" {
+		return id
+	}
+	return fmt.Sprintf("#%d", unported)
+}
@@ -0,0 +1,157 @@
+package merge_test
+
+import (
+	"errors"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"istio.io/api/networking/v1alpha3"
+)
+
+var _ = Describe("Merge", func() {
+	Context("method merge.Merge(base, patches, opts)", func() {
+		httpRoute := func(name, prefix string) *v1alpha3.HTTPRoute {
+			return &v1alpha3.HTTPRoute{
+				Name: name,
+				Match: []*v1alpha3.HTTPMatchRequest{{
+					Uri: &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Prefix{Prefix: prefix}},
+				}},
+			}
+		}
+		tcpRoute := func(host string, ports ...uint32) *v1alpha3.TCPRoute {
+			route := &v1alpha3.TCPRoute{Route: []*v1alpha3.RouteDestination{{
+				Destination: &v1alpha3.Destination{Host: host},
+			}}}
+			for _, port := range ports {
+				route.Match = append(route.Match, &v1alpha3.L4MatchAttributes{Port: port})
+			}
+			return route
+		}
+		names := func(routes []*v1alpha3.HTTPRoute) []string {
+			var out []string
+			for _, route := range routes {
+				out = append(out, route.Name)
+			}
+			return out
+		}
+
+		It("will name, add and sort the http routes without changing its arguments", func() {
+			base := &v1alpha3.VirtualService{Http: []*v1alpha3.HTTPRoute{{Name: "default"}}}
+			patch := merge.Patch{Name: "reviews", Source: "app/reviews", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{httpRoute("", "/reviews/v2"), httpRoute("", "/reviews")},
+			}}
+
+			result, report, err := merge.Merge(base, []merge.Patch{patch}, merge.Options{})
+
+			Expect(err).To(BeNil())
+			Expect(names(result.Http)).To(Equal([]string{"reviews-1", "reviews-0", "default"}))
+			Expect(report.Added).To(ConsistOf(
+				merge.Change{Route: "http/reviews-1", Source: "app/reviews"},
+				merge.Change{Route: "http/reviews-0", Source: "app/reviews"},
+			))
+			Expect(report.Replaced).To(BeEmpty())
+			Expect(names(base.Http)).To(Equal([]string{"default"}))
+			Expect(names(patch.Spec.Http)).To(Equal([]string{"", ""}))
+		})
+
+		It("will replace and remove the routes of the patch", func() {
+			base := &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{httpRoute("reviews-0", "/old")},
+				Tcp:  []*v1alpha3.TCPRoute{tcpRoute("mysql", 3306)},
+			}
+			patch := merge.Patch{Name: "reviews", Source: "app/reviews", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{httpRoute("", "/reviews")},
+				Tcp:  []*v1alpha3.TCPRoute{tcpRoute("mariadb", 3306), tcpRoute("redis", 6379)},
+			}}
+
+			result, report, err := merge.Merge(base, []merge.Patch{patch}, merge.Options{})
+
+			Expect(err).To(BeNil())
+			Expect(result.Http[0].Match[0].Uri.GetPrefix()).To(Equal("/reviews"))
+			Expect(result.Tcp).To(HaveLen(2))
+			Expect(result.Tcp[0].Route[0].Destination.Host).To(Equal("mariadb"))
+			Expect(report.Replaced).To(ConsistOf(
+				merge.Change{Route: "http/reviews-0", Source: "app/reviews"},
+				merge.Change{Route: "tcp/port-3306", Source: "app/reviews"},
+			))
+			Expect(report.Added).To(ConsistOf(merge.Change{Route: "tcp/port-6379", Source: "app/reviews"}))
+
+			result, report, err = merge.Merge(result, []merge.Patch{patch}, merge.Options{Remove: true})
+
+			Expect(err).To(BeNil())
+			Expect(result.Http).To(BeEmpty())
+			Expect(result.Tcp).To(BeEmpty())
+			Expect(report.Removed).To(HaveLen(3))
+		})
+
+		It("will reject the routes owned by another source", func() {
+			base := &v1alpha3.VirtualService{Http: []*v1alpha3.HTTPRoute{httpRoute("shared-0", "/")}}
+			first := merge.Patch{Name: "first", Source: "app/first", Spec: &v1alpha3.VirtualService{
+				Tcp: []*v1alpha3.TCPRoute{tcpRoute("a", 80)},
+			}}
+			second := merge.Patch{Name: "second", Source: "app/second", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{httpRoute("shared-0", "/shared")},
+				Tcp:  []*v1alpha3.TCPRoute{tcpRoute("b", 80, 81)},
+			}}
+
+			result, report, err := merge.Merge(base, []merge.Patch{first, second}, merge.Options{
+				Owners: map[string]string{"http/shared-0": "app/owner"},
+			})
+
+			Expect(errors.Is(err, merge.ErrRouteConflict)).To(BeTrue())
+			Expect(result).To(BeNil())
+			Expect(report.Conflicts).To(ConsistOf(
+				merge.Conflict{Route: "http/shared-0", Sources: []string{"app/owner", "app/second"}},
+				merge.Conflict{Route: "tcp/port-80", Sources: []string{"app/first", "app/second"}},
+			))
+		})
+
+		It("will keep the routes of other sources when removing", func() {
+			base := &v1alpha3.VirtualService{Http: []*v1alpha3.HTTPRoute{httpRoute("shared-0", "/")}}
+			patch := merge.Patch{Name: "second", Source: "app/second", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{httpRoute("shared-0", "/shared")},
+			}}
+
+			result, report, err := merge.Merge(base, []merge.Patch{patch}, merge.Options{
+				Remove: true,
+				Owners: map[string]string{"http/shared-0": "app/owner"},
+			})
+
+			Expect(err).To(BeNil())
+			Expect(result.Http).To(HaveLen(1))
+			Expect(report.Removed).To(BeEmpty())
+		})
+
+		It("will fail without a base", func() {
+			_, _, err := merge.Merge(nil, nil, merge.Options{})
+
+			Expect(err).To(Equal(merge.ErrNoBase))
+		})
+	})
+
+	Context("method merge.MergeSource(base, patch, retired, opts)", func() {
+		It("will remove the retired routes of the source before merging its patch", func() {
+			base := &v1alpha3.VirtualService{Http: []*v1alpha3.HTTPRoute{
+				{Name: "reviews-0"}, {Name: "ratings-0"}, {Name: "default"},
+			}}
+			patch := merge.Patch{Name: "reviews-delegate", Source: "app/reviews", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{{Name: "reviews-delegate-0"}},
+			}}
+			retired := merge.Patch{Name: "reviews", Source: "app/reviews", Spec: &v1alpha3.VirtualService{
+				Http: []*v1alpha3.HTTPRoute{{Name: "reviews-0"}},
+			}}
+
+			result, report, err := merge.MergeSource(base, patch, retired, merge.Options{
+				Owners: map[string]string{"http/reviews-0": "app/reviews", "http/ratings-0": "app/ratings"},
+			})
+
+			Expect(err).To(BeNil())
+			Expect(report.Added).To(ConsistOf(merge.Change{Route: "http/reviews-delegate-0", Source: "app/reviews"}))
+			var names []string
+			for _, route := range result.Http {
+				names = append(names, route.Name)
+			}
+			Expect(names).To(ConsistOf("reviews-delegate-0", "ratings-0", "default"))
+		})
+	})
+})
@@ -0,0 +1,514 @@
+package merge_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/gogo/protobuf/proto"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"istio.io/api/networking/v1alpha3"
+)
+
+// baseSource owns the routes of the base when the scenario says so
+const baseSource = "test/base"
+
+// scenario is a base with patches to merge into it, decoded from bytes. The patches may name
+// the routes of the base or of each other, or share their ports, in which case they collide.
+// Every route routes to the name of the patch, or to "base", which tells who wrote it.
+type scenario struct {
+	base    *v1alpha3.VirtualService
+	patches []merge.Patch
+	// owned makes the base routes owned by baseSource, as the routes of another merge are
+	owned bool
+}
+
+// byteReader decodes the choices of a scenario; once the bytes run out every choice is 0
+type byteReader []byte
+
+func (r *byteReader) intn(n int) int {
+	if len(*r) == 0 {
+		return 0
+	}
+	v := int((*r)[0]) % n
+	*r = (*r)[1:]
+	return v
+}
+
+// newScenario decodes the scenario of the bytes. The http routes have distinct precedences
+// unless they collide, so that the order of the routes never depends on the order of the patches.
+func newScenario(data []byte) *scenario {
+	r := byteReader(data)
+	free := make([]int, 0, 99)
+	for p := 1; p < 100; p++ {
+		free = append(free, p)
+	}
+	precedence := func() int {
+		i := r.intn(len(free))
+		p := free[i]
+		free = append(free[:i], free[i+1:]...)
+		return p
+	}
+	s := &scenario{base: &v1alpha3.VirtualService{Hosts: []string{"api.monime.sl"}}, owned: r.intn(2) == 1}
+	for i := r.intn(4); i > 0; i-- {
+		s.base.Http = append(s.base.Http, sourceHttpRoute(fmt.Sprintf("base-%d", precedence()), fmt.Sprintf("/base/%d", i), "base"))
+	}
+	// the base is sorted as a merge sorts it, for removing the patches to restore it exactly
+	sort.SliceStable(s.base.Http, func(i, j int) bool {
+		return merge.RoutePrecedence(s.base.Http[i].Name) > merge.RoutePrecedence(s.base.Http[j].Name)
+	})
+	if r.intn(2) == 0 {
+		// the default route of the base, without a match
+		s.base.Http = append(s.base.Http, &v1alpha3.HTTPRoute{Route: httpDestinations("base")})
+	}
+	for i := r.intn(3); i > 0; i-- {
+		s.base.Tcp = append(s.base.Tcp, tcpRoute("base", uint32(1000+i)))
+	}
+	for i := r.intn(3); i > 0; i-- {
+		s.base.Tls = append(s.base.Tls, tlsRoute("base", uint32(2000+i)))
+	}
+
+	port := uint32(3000)
+	for p := r.intn(3) + 1; p > 0; p-- {
+		name := fmt.Sprintf("patch%d", p)
+		spec := &v1alpha3.VirtualService{}
+		for i := r.intn(4); i > 0; i-- {
+			spec.Http = append(spec.Http, sourceHttpRoute(fmt.Sprintf("%s-%d", name, precedence()), fmt.Sprintf("/%s/%d", name, i), name))
+		}
+		for i := r.intn(3); i > 0; i-- {
+			port++
+			spec.Tcp = append(spec.Tcp, tcpRoute(name, port))
+		}
+		for i := r.intn(3); i > 0; i-- {
+			port++
+			spec.Tls = append(spec.Tls, tlsRoute(name, port))
+		}
+		s.patches = append(s.patches, merge.Patch{Name: name, Source: "test/" + name, Spec: spec})
+	}
+
+	// the collisions: a patch writes a route of the base or of another patch
+	for c := r.intn(5); c > 0; c-- {
+		patch := s.patches[r.intn(len(s.patches))]
+		other := s.base
+		if kind := r.intn(len(s.patches) + 1); kind > 0 {
+			other = s.patches[kind-1].Spec
+		}
+		if other == patch.Spec {
+			continue
+		}
+		switch r.intn(3) {
+		case 0:
+			var named []*v1alpha3.HTTPRoute
+			for _, route := range other.Http {
+				if route.Name != "" {
+					named = append(named, route)
+				}
+			}
+			if len(named) > 0 {
+				name := named[r.intn(len(named))].Name
+				if !hasHttpRoute(patch.Spec, name) {
+					patch.Spec.Http = append(patch.Spec.Http, sourceHttpRoute(name, "/"+patch.Name+"/"+name, patch.Name))
+				}
+			}
+		case 1:
+			if len(other.Tcp) > 0 {
+				route := tcpRoute(patch.Name, other.Tcp[r.intn(len(other.Tcp))].Match[0].Port)
+				if !hasTcpRoute(patch.Spec, route) {
+					patch.Spec.Tcp = append(patch.Spec.Tcp, route)
+				}
+			}
+		case 2:
+			if len(other.Tls) > 0 {
+				route := tlsRoute(patch.Name, other.Tls[r.intn(len(other.Tls))].Match[0].Port)
+				if !hasTlsRoute(patch.Spec, route) {
+					patch.Spec.Tls = append(patch.Spec.Tls, route)
+				}
+			}
+		}
+	}
+	return s
+}
+
+func hasHttpRoute(spec *v1alpha3.VirtualService, name string) bool {
+	for _, route := range spec.Http {
+		if route.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTcpRoute(spec *v1alpha3.VirtualService, route *v1alpha3.TCPRoute) bool {
+	for _, current := range spec.Tcp {
+		if merge.SameTcpRoute(current, route) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTlsRoute(spec *v1alpha3.VirtualService, route *v1alpha3.TLSRoute) bool {
+	for _, current := range spec.Tls {
+		if merge.SameTlsRoute(current, route) {
+			return true
+		}
+	}
+	return false
+}
+
+func httpRoute(name, prefix string) *v1alpha3.HTTPRoute {
+	return sourceHttpRoute(name, prefix, name)
+}
+
+func sourceHttpRoute(name, prefix, host string) *v1alpha3.HTTPRoute {
+	return &v1alpha3.HTTPRoute{
+		Name: name,
+		Match: []*v1alpha3.HTTPMatchRequest{{
+			Uri: &v1alpha3.StringMatch{MatchType: &v1alpha3.StringMatch_Prefix{Prefix: prefix}},
+		}},
+		Route: httpDestinations(host),
+	}
+}
+
+func tcpRoute(host string, port uint32) *v1alpha3.TCPRoute {
+	return &v1alpha3.TCPRoute{
+		Match: []*v1alpha3.L4MatchAttributes{{Port: port}},
+		Route: destinations(host),
+	}
+}
+
+func tlsRoute(host string, port uint32) *v1alpha3.TLSRoute {
+	return &v1alpha3.TLSRoute{
+		Match: []*v1alpha3.TLSMatchAttributes{{Port: port, SniHosts: []string{host}}},
+		Route: destinations(host),
+	}
+}
+
+func destinations(host string) []*v1alpha3.RouteDestination {
+	return []*v1alpha3.RouteDestination{{Destination: &v1alpha3.Destination{Host: host}}}
+}
+
+func httpDestinations(host string) []*v1alpha3.HTTPRouteDestination {
+	return []*v1alpha3.HTTPRouteDestination{{Destination: &v1alpha3.Destination{Host: host}}}
+}
+
+func routeCount(vs *v1alpha3.VirtualService) int {
+	return len(vs.Http) + len(vs.Tcp) + len(vs.Tls)
+}
+
+// routeWriters maps the ids of the routes of the VirtualService to the host they route to,
+// i.e. to the name of the patch which wrote them
+func routeWriters(vs *v1alpha3.VirtualService) map[string]string {
+	writers := map[string]string{}
+	for _, route := range vs.Http {
+		writers["http/"+route.Name] = route.Route[0].Destination.Host
+	}
+	for _, route := range vs.Tcp {
+		writers["tcp/"+merge.PortsId([]uint32{route.Match[0].Port})] = route.Route[0].Destination.Host
+	}
+	for _, route := range vs.Tls {
+		writers["tls/"+merge.PortsId([]uint32{route.Match[0].Port})] = route.Route[0].Destination.Host
+	}
+	return writers
+}
+
+// patchIds returns the ids of the routes of the patch
+func patchIds(patch merge.Patch) []string {
+	var ids []string
+	for _, route := range merge.NameHttpRoutes(logr.Discard(), patch.Name, patch.Spec.Http) {
+		ids = append(ids, "http/"+route.Name)
+	}
+	for _, route := range patch.Spec.Tcp {
+		ids = append(ids, "tcp/"+merge.PortsId([]uint32{route.Match[0].Port}))
+	}
+	for _, route := range patch.Spec.Tls {
+		ids = append(ids, "tls/"+merge.PortsId([]uint32{route.Match[0].Port}))
+	}
+	return ids
+}
+
+// model is what a merge must do: a patch writes its routes unless one of them is owned by another
+// source, in which case it conflicts and writes nothing, and a patch removes the routes it owns
+// or which nobody owns. The operator merges the patches one by one this way.
+type model struct {
+	writers map[string]string
+	owners  map[string]string
+}
+
+func newModel(s *scenario) *model {
+	m := &model{writers: routeWriters(s.base), owners: map[string]string{}}
+	if s.owned {
+		for id := range m.writers {
+			if id != "http/" {
+				m.owners[id] = baseSource
+			}
+		}
+	}
+	return m
+}
+
+func (m *model) ownersCopy() map[string]string {
+	owners := map[string]string{}
+	for id, source := range m.owners {
+		owners[id] = source
+	}
+	return owners
+}
+
+// conflicts returns the routes of the patches owned by another source when merging them at once;
+// each route is written by the first patch writing it
+func (m *model) conflicts(patches []merge.Patch) []string {
+	owners := m.ownersCopy()
+	conflicting := map[string]bool{}
+	for _, patch := range patches {
+		for _, id := range patchIds(patch) {
+			if owner, ok := owners[id]; ok && owner != patch.Source {
+				conflicting[id] = true
+			} else {
+				owners[id] = patch.Source
+			}
+		}
+	}
+	return sortedKeys(conflicting)
+}
+
+func (m *model) add(patch merge.Patch) []string {
+	if conflicts := m.conflicts([]merge.Patch{patch}); len(conflicts) > 0 {
+		return conflicts
+	}
+	for _, id := range patchIds(patch) {
+		m.writers[id] = patch.Name
+		m.owners[id] = patch.Source
+	}
+	return nil
+}
+
+func (m *model) remove(patch merge.Patch) {
+	for _, id := range patchIds(patch) {
+		if _, ok := m.writers[id]; !ok {
+			continue
+		}
+		if owner, ok := m.owners[id]; !ok || owner == patch.Source {
+			delete(m.writers, id)
+			delete(m.owners, id)
+		}
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkConflicts checks that a merge fails with the conflicts of the model, and only then
+func checkConflicts(result *v1alpha3.VirtualService, report *merge.Report, err error, want []string) error {
+	if len(want) == 0 {
+		return err
+	}
+	if !errors.Is(err, merge.ErrRouteConflict) {
+		return fmt.Errorf("the conflicts on %v are not detected: %v", want, err)
+	}
+	if result != nil {
+		return fmt.Errorf("a conflicting merge returned a result")
+	}
+	got := map[string]bool{}
+	for _, conflict := range report.Conflicts {
+		got[conflict.Route] = true
+	}
+	if !reflect.DeepEqual(sortedKeys(got), want) {
+		return fmt.Errorf("the conflicts are %v, expected %v", sortedKeys(got), want)
+	}
+	return nil
+}
+
+// step merges or removes the patch as the operator does, checking the result against the model
+func step(m *model, vs *v1alpha3.VirtualService, patch merge.Patch, remove bool) (*v1alpha3.VirtualService, *merge.Report, error) {
+	result, report, err := merge.Merge(vs, []merge.Patch{patch}, merge.Options{Remove: remove, Owners: m.ownersCopy()})
+	var conflicts []string
+	if remove {
+		m.remove(patch)
+	} else {
+		conflicts = m.add(patch)
+	}
+	if err := checkConflicts(result, report, err, conflicts); err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", action(remove), patch.Source, err)
+	}
+	if len(conflicts) > 0 {
+		return vs, report, nil
+	}
+	if got := routeWriters(result); !reflect.DeepEqual(got, m.writers) {
+		return nil, nil, fmt.Errorf("%s %s: the routes are written by %v, expected %v", action(remove), patch.Source, got, m.writers)
+	}
+	return result, report, nil
+}
+
+func action(remove bool) string {
+	if remove {
+		return "removing"
+	}
+	return "merging"
+}
+
+// checkConflictDetection checks that merging the patches at once fails exactly on the routes
+// written by several sources
+func checkConflictDetection(s *scenario) error {
+	m := newModel(s)
+	result, report, err := merge.Merge(s.base, s.patches, merge.Options{Owners: m.ownersCopy()})
+	return checkConflicts(result, report, err, m.conflicts(s.patches))
+}
+
+// checkApplyRemove checks that merging the patches one by one and removing them all restores
+// the base, but for the routes nobody owned which a patch replaced
+func checkApplyRemove(s *scenario) error {
+	m := newModel(s)
+	vs := s.base
+	var err error
+	for _, patch := range s.patches {
+		if vs, _, err = step(m, vs, patch, false); err != nil {
+			return err
+		}
+	}
+	for i := len(s.patches) - 1; i >= 0; i-- {
+		if vs, _, err = step(m, vs, s.patches[i], true); err != nil {
+			return err
+		}
+	}
+	if reflect.DeepEqual(m.writers, routeWriters(s.base)) && !proto.Equal(vs, s.base) {
+		return fmt.Errorf("removing the patches does not restore the base:\nbase: %v\ngot:  %v", s.base, vs)
+	}
+	return nil
+}
+
+// checkOrderIndependence checks that neither the conflicts nor the result depend on the order of
+// the patches. The tcp and tls routes are appended in order, so only their set is compared.
+func checkOrderIndependence(s *scenario) error {
+	owners := newModel(s).owners
+	merged, _, err := merge.Merge(s.base, s.patches, merge.Options{Owners: owners})
+	reversed := make([]merge.Patch, len(s.patches))
+	for i, patch := range s.patches {
+		reversed[len(s.patches)-1-i] = patch
+	}
+	other, _, otherErr := merge.Merge(s.base, reversed, merge.Options{Owners: owners})
+	if errors.Is(err, merge.ErrRouteConflict) != errors.Is(otherErr, merge.ErrRouteConflict) {
+		return fmt.Errorf("the conflicts depend on the order of the patches: %v, reversed: %v", err, otherErr)
+	}
+	if err != nil || otherErr != nil {
+		return checkConflictDetection(s)
+	}
+	for _, vs := range []*v1alpha3.VirtualService{merged, other} {
+		sort.SliceStable(vs.Tcp, func(i, j int) bool { return vs.Tcp[i].Match[0].Port < vs.Tcp[j].Match[0].Port })
+		sort.SliceStable(vs.Tls, func(i, j int) bool { return vs.Tls[i].Match[0].Port < vs.Tls[j].Match[0].Port })
+	}
+	if !proto.Equal(merged, other) {
+		return fmt.Errorf("the result depends on the order of the patches:\nin order: %v\nreversed: %v", merged, other)
+	}
+	return nil
+}
+
+// checkForeignRoutes checks that, once every patch is merged, removing any of them,
+// including the conflicting ones, leaves the routes of the other sources untouched
+func checkForeignRoutes(s *scenario) error {
+	m := newModel(s)
+	vs := s.base
+	var err error
+	for _, patch := range s.patches {
+		if vs, _, err = step(m, vs, patch, false); err != nil {
+			return err
+		}
+	}
+	for _, patch := range s.patches {
+		removing := &model{writers: map[string]string{}, owners: m.ownersCopy()}
+		for id, writer := range m.writers {
+			removing.writers[id] = writer
+		}
+		if _, _, err := step(removing, vs, patch, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRouteCount checks that the routes are only added or removed as the report says
+func checkRouteCount(s *scenario) error {
+	m := newModel(s)
+	vs := s.base
+	for i, patch := range append(append([]merge.Patch{}, s.patches...), s.patches...) {
+		remove := i >= len(s.patches)
+		result, report, err := step(m, vs, patch, remove)
+		if err != nil {
+			return err
+		}
+		want := routeCount(vs) + len(report.Added)
+		if remove {
+			want = routeCount(vs) - len(report.Removed)
+		} else if len(report.Conflicts) > 0 {
+			// the conflicting patch is not merged
+			want = routeCount(vs)
+		}
+		if got := routeCount(result); got != want {
+			return fmt.Errorf("%s %s: %d routes, expected %d", action(remove), patch.Source, got, want)
+		}
+		vs = result
+	}
+	return nil
+}
+
+var properties = map[string]func(s *scenario) error{
+	"the conflicts are detected":             checkConflictDetection,
+	"apply then remove restores the base":    checkApplyRemove,
+	"the order of the patches is irrelevant": checkOrderIndependence,
+	"foreign routes are kept":                checkForeignRoutes,
+	"the route count is conserved":           checkRouteCount,
+}
+
+// scenarioBytes returns the random bytes of the seed
+func scenarioBytes(seed int64) []byte {
+	data := make([]byte, 64)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func FuzzMerge(f *testing.F) {
+	for seed := int64(0); seed < 8; seed++ {
+		f.Add(scenarioBytes(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for name, property := range properties {
+			if err := property(newScenario(data)); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	})
+}
+
+var _ = Describe("Merge properties", func() {
+	It("will generate colliding scenarios", func() {
+		collisions := 0
+		for seed := int64(0); seed < 500; seed++ {
+			s := newScenario(scenarioBytes(seed))
+			if len(newModel(s).conflicts(s.patches)) > 0 {
+				collisions++
+			}
+		}
+		Expect(collisions).To(BeNumerically(">", 50))
+	})
+
+	for name, property := range properties {
+		property := property
+		It("will hold: "+name, func() {
+			for seed := int64(0); seed < 500; seed++ {
+				Expect(property(newScenario(scenarioBytes(seed)))).To(Succeed(), "seed %d", seed)
+			}
+		})
+	}
+})
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2021 - now, the original author or authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *       https://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package merge
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/internal/logging"
+	"istio.io/api/networking/v1alpha3"
+)
+
+// NameHttpRoutes returns copies of the http routes of the named patch named as they are merged:
+// a route whose name does not end with a precedence is named <patch name>-<precedence>, the
+// precedence decreasing down the list so that the first route stays above the others.
+func NameHttpRoutes(log logr.Logger, name string, routes []*v1alpha3.HTTPRoute) []*v1alpha3.HTTPRoute {
+	named := make([]*v1alpha3.HTTPRoute, len(routes))
+	for i, r := range routes {
+		r = r.DeepCopy()
+		original := r.Name
+		if r.Name == "" {
+			// make the precedence decrease as we go down the list.
+			precedence := int64(len(routes) - i - 1)
+			r.Name = fmt.Sprintf("%s-%d", name, precedence)
+		} else if _, _, ok := parsePrecedence(log, r.Name); !ok {
+			// make the precedence decrease as we go down the list.
+			precedence := int64(len(routes) - i - 1)
+			r.Name = fmt.Sprintf("%s-%d", name, precedence)
+		}
+		named[i] = r
+		if r.Name != original {
+			log.V(1).Info("Named the patch route", logging.KeyRoute, "http/"+r.Name, "original", original)
+		}
+	}
+	return named
+}
+
+// RoutePrecedence returns the precedence encoded in the name of a merged http route
+func RoutePrecedence(name string) int {
+	_, precedence, _ := parsePrecedence(logr.Discard(), name)
+	return precedence
+}
+
+// PortsId identifies a tcp or tls route by its ports, e.g. port-80,81; it is empty without ports
+func PortsId(ports []uint32) string {
+	if len(ports) == 0 {
+		return ""
+	}
+	ids := make([]string, len(ports))
+	for i, port := range ports {
+		ids[i] = fmt.Sprint(port)
+	}
+	return "port-" + strings.Join(ids, ",")
+}
+
+// RouteIds identifies the routes of the spec, in the order of its http, tcp and tls routes, by
+// their kind and name, e.g. "http/reviews-1", or by their ports for the tcp and tls routes. The
+// routes without a name or ports are numbered among themselves, e.g. "tcp/#0", so that inserting
+// an identified route does not change their ids.
+func RouteIds(spec *v1alpha3.VirtualService) []string {
+	var ids []string
+	unnamed := 0
+	for _, route := range spec.Http {
+		id := route.Name
+		if id == "" {
+			id = fmt.Sprintf("#%d", unnamed)
+			unnamed++
+		}
+		ids = append(ids, "http/"+id)
+	}
+	unnamed = 0
+	for _, route := range spec.Tcp {
+		ids = append(ids, "tcp/"+portsOrIndex(tcpPorts(route), &unnamed))
+	}
+	unnamed = 0
+	for _, route := range spec.Tls {
+		ids = append(ids, "tls/"+portsOrIndex(tlsPorts(route), &unnamed))
+	}
+	return ids
+}
+
+func portsOrIndex(ports []uint32, unnamed *int) string {
+	if len(ports) == 0 {
+		*unnamed++
+		return fmt.Sprintf("#%d", *unnamed-1)
+	}
+	return PortsId(ports)
+}
+
+// SameTcpRoute checks if the routes match a common port, in which case a patch route replaces a base route
+func SameTcpRoute(a, b *v1alpha3.TCPRoute) bool {
+	return portsOverlap(tcpPorts(a), tcpPorts(b))
+}
+
+// SameTlsRoute checks if the routes match a common port, in which case a patch route replaces a base route
+func SameTlsRoute(a, b *v1alpha3.TLSRoute) bool {
+	return portsOverlap(tlsPorts(a), tlsPorts(b))
+}
+
+func sortHttpRoutes(log logr.Logger, routes []*v1alpha3.HTTPRoute) []*v1alpha3.HTTPRoute {
+	sort.SliceStable(routes, func(i, j int) bool {
+		_, iPrecedence, _ := parsePrecedence(log, routes[i].Name)
+		_, jPrecedence, _ := parsePrecedence(log, routes[j].Name)
+		return iPrecedence > jPrecedence
+	})
+	return routes
+}
+
+func parsePrecedence(log logr.Logger, name string) (string, int, bool) {
+	parts := strings.Split(name, "-")
+	if len(parts) <= 1 {
+		return name, 0, false
+	}
+	precedenceStr := parts[len(parts)-1]
+	precedence, err := strconv.ParseInt(precedenceStr, 10, 64)
+	if err != nil {
+		log.V(1).Info("No precedence for route. Defaulting to 0", logging.KeyRoute, "http/"+name)
+		return name, 0, false
+	}
+	return strings.Join(parts[:len(parts)-1], "-"), int(precedence), true
+}
+
+func indexOfTcpRoute(routes []*v1alpha3.TCPRoute, route *v1alpha3.TCPRoute) int {
+	for i, current := range routes {
+		if SameTcpRoute(current, route) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfTlsRoute(routes []*v1alpha3.TLSRoute, route *v1alpha3.TLSRoute) int {
+	for i, current := range routes {
+		if SameTlsRoute(current, route) {
+			return i
+		}
+	}
+	return -1
+}
+
+func unportedTcpRoutes(routes []*v1alpha3.TCPRoute) int {
+	count := 0
+	for _, route := range routes {
+		if len(tcpPorts(route)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func unportedTlsRoutes(routes []*v1alpha3.TLSRoute) int {
+	count := 0
+	for _, route := range routes {
+		if len(tlsPorts(route)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func tcpPorts(route *v1alpha3.TCPRoute) []uint32 {
+	var ports []uint32
+	for _, match := range route.Match {
+		ports = append(ports, match.Port)
+	}
+	return ports
+}
+
+func tlsPorts(route *v1alpha3.TLSRoute) []uint32 {
+	var ports []uint32
+	for _, match := range route.Match {
+		ports = append(ports, match.Port)
+	}
+	return ports
+}
+
+// portsOverlap checks if the ports share one; port equality is treated as route equality
+func portsOverlap(a, b []uint32) bool {
+	for _, pa := range a {
+		for _, pb := range b {
+			if pa == pb {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package merge_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Merge library test suite")
+}
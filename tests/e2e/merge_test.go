@@ -0,0 +1,176 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	"istio.io/client-go/pkg/apis/networking/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	timeout  = 20 * time.Second
+	interval = 100 * time.Millisecond
+)
+
+var _ = Describe("VirtualServiceMerge", func() {
+	var namespace string
+
+	BeforeEach(func() {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "e2e-"}}
+		Expect(k8sClient.Create(context.TODO(), ns)).To(Succeed())
+		namespace = ns.Name
+	})
+
+	newTarget := func(name string) *v1alpha3.VirtualService {
+		target := &v1alpha3.VirtualService{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		target.Spec.Hosts = []string{name + ".monime.sl"}
+		target.Spec.Http = []*networkingv1alpha3.HTTPRoute{{
+			Name: "default",
+			Route: []*networkingv1alpha3.HTTPRouteDestination{{
+				Destination: &networkingv1alpha3.Destination{Host: "default-backend"},
+			}},
+		}}
+		Expect(k8sClient.Create(context.TODO(), target)).To(Succeed())
+		return target
+	}
+
+	newMerge := func(name, target, prefix string) *v1alpha1.VirtualServiceMerge {
+		merge := &v1alpha1.VirtualServiceMerge{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		merge.Spec.Target.Name = target
+		merge.Spec.Patch.Http = []*networkingv1alpha3.HTTPRoute{{
+			Match: []*networkingv1alpha3.HTTPMatchRequest{{
+				Uri: &networkingv1alpha3.StringMatch{MatchType: &networkingv1alpha3.StringMatch_Prefix{Prefix: prefix}},
+			}},
+			Route: []*networkingv1alpha3.HTTPRouteDestination{{
+				Destination: &networkingv1alpha3.Destination{Host: name},
+			}},
+		}}
+		Expect(k8sClient.Create(context.TODO(), merge)).To(Succeed())
+		return merge
+	}
+
+	// routes returns the prefixes of the target http routes by their name
+	routes := func(target string) func() (map[string]string, error) {
+		return func() (map[string]string, error) {
+			vs := &v1alpha3.VirtualService{}
+			if err := k8sClient.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: target}, vs); err != nil {
+				return nil, err
+			}
+			out := map[string]string{}
+			for _, route := range vs.Spec.Http {
+				prefix := ""
+				if len(route.Match) > 0 {
+					prefix = route.Match[0].GetUri().GetPrefix()
+				}
+				out[route.Name] = prefix
+			}
+			return out, nil
+		}
+	}
+
+	appliedReason := func(name string) func() string {
+		return func() string {
+			merge := &v1alpha1.VirtualServiceMerge{}
+			if err := k8sClient.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, merge); err != nil {
+				return err.Error()
+			}
+			if condition := meta.FindStatusCondition(merge.Status.Conditions, v1alpha1.ConditionApplied); condition != nil {
+				return condition.Reason
+			}
+			return ""
+		}
+	}
+
+	// update applies the change to the latest version of the object
+	update := func(obj client.Object, change func()) {
+		Eventually(func() error {
+			if err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); err != nil {
+				return err
+			}
+			change()
+			return k8sClient.Update(context.TODO(), obj)
+		}, timeout, interval).Should(Succeed())
+	}
+
+	It("will merge, update and remove the patch", func() {
+		newTarget("api")
+		merge := newMerge("reviews", "api", "/reviews")
+
+		Eventually(routes("api"), timeout, interval).Should(Equal(map[string]string{
+			"reviews-0": "/reviews",
+			"default":   "",
+		}))
+		Eventually(appliedReason("reviews"), timeout, interval).Should(Equal(v1alpha1.ReasonApplied))
+		Expect(k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(merge), merge)).To(Succeed())
+		Expect(merge.Finalizers).To(ContainElement("istiomerger.monime.sl-finalizer"))
+
+		update(merge, func() {
+			merge.Spec.Patch.Http[0].Match[0].Uri.MatchType = &networkingv1alpha3.StringMatch_Prefix{Prefix: "/reviews/v2"}
+		})
+		Eventually(routes("api"), timeout, interval).Should(HaveKeyWithValue("reviews-0", "/reviews/v2"))
+
+		Expect(k8sClient.Delete(context.TODO(), merge)).To(Succeed())
+		Eventually(routes("api"), timeout, interval).Should(Equal(map[string]string{"default": ""}))
+		Eventually(func() bool {
+			err := k8sClient.Get(context.TODO(), client.ObjectKeyFromObject(merge), merge)
+			return kerr.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("will move the patch to its new target", func() {
+		newTarget("old")
+		newTarget("new")
+		merge := newMerge("reviews", "old", "/reviews")
+		Eventually(routes("old"), timeout, interval).Should(HaveKey("reviews-0"))
+
+		update(merge, func() { merge.Spec.Target.Name = "new" })
+
+		Eventually(routes("new"), timeout, interval).Should(HaveKey("reviews-0"))
+		Eventually(routes("old"), timeout, interval).Should(Equal(map[string]string{"default": ""}))
+	})
+
+	It("will merge the patch again into a recreated target", func() {
+		target := newTarget("api")
+		newMerge("reviews", "api", "/reviews")
+		Eventually(routes("api"), timeout, interval).Should(HaveKey("reviews-0"))
+
+		Expect(k8sClient.Delete(context.TODO(), target)).To(Succeed())
+		Eventually(appliedReason("reviews"), timeout, interval).Should(Equal(v1alpha1.ReasonTargetMissing))
+
+		newTarget("api")
+		Eventually(routes("api"), timeout, interval).Should(HaveKey("reviews-0"))
+		Eventually(appliedReason("reviews"), timeout, interval).Should(Equal(v1alpha1.ReasonApplied))
+	})
+
+	It("will merge concurrent patches into one target", func() {
+		const count = 8
+		newTarget("api")
+		var merges []*v1alpha1.VirtualServiceMerge
+		expected := map[string]string{"default": ""}
+		for i := 0; i < count; i++ {
+			name := fmt.Sprintf("team%d", i)
+			merges = append(merges, newMerge(name, "api", "/"+name))
+			expected[name+"-0"] = "/" + name
+		}
+
+		Eventually(routes("api"), timeout, interval).Should(Equal(expected))
+
+		for _, merge := range merges {
+			Expect(k8sClient.Delete(context.TODO(), merge)).To(Succeed())
+		}
+		Eventually(routes("api"), timeout, interval).Should(Equal(map[string]string{"default": ""}))
+	})
+})
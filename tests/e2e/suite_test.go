@@ -0,0 +1,83 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	configv1alpha1 "github.com/monimesl/istio-virtualservice-merger/api/config/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	controllers "github.com/monimesl/istio-virtualservice-merger/controller"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var (
+	testEnv   *envtest.Environment
+	k8sClient client.Client
+	stop      context.CancelFunc
+)
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "End to end test suite")
+}
+
+var _ = BeforeSuite(func() {
+	// the control plane binaries are looked up in KUBEBUILDER_ASSETS, see the e2e target of the Makefile
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "manifest", "crd.yaml"),
+			filepath.Join("testdata", "virtualservice-crd.yaml"),
+		},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := testEnv.Start()
+	Expect(err).NotTo(HaveOccurred())
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha3.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	// the manager and the controller are configured as main.go does, from the defaults of the
+	// operator configuration, with the single instance serving neither the metrics nor the probes
+	operatorConfig := configv1alpha1.NewDefault()
+	operatorConfig.Metrics.BindAddress = "0"
+	operatorConfig.Health.BindAddress = "0"
+	operatorConfig.LeaderElection.Enabled = false
+	Expect(operatorConfig.Validate()).To(Succeed())
+	options := manager.Options{Scheme: scheme}
+	operatorConfig.ApplyTo(&options)
+	mgr, err := manager.New(cfg, options)
+	Expect(err).NotTo(HaveOccurred())
+	opts := controllers.Options{RevisionHistoryLimit: 10}
+	opts.Configure(operatorConfig)
+	Expect(controllers.SetupWithManager(mgr, opts, false)).To(Succeed())
+	var ctx context.Context
+	ctx, stop = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+})
+
+var _ = AfterSuite(func() {
+	if stop != nil {
+		stop()
+	}
+	if testEnv != nil {
+		Expect(testEnv.Stop()).To(Succeed())
+	}
+})
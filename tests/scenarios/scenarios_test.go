@@ -0,0 +1,179 @@
+package scenarios
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/monimesl/istio-virtualservice-merger/api/v1alpha1"
+	"github.com/monimesl/istio-virtualservice-merger/internal/cli"
+	"github.com/monimesl/istio-virtualservice-merger/internal/diff"
+	"github.com/monimesl/istio-virtualservice-merger/pkg/merge"
+	istio "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var update = flag.Bool("update", false, "Write the results of the steps to their expected files")
+
+// TestScenarios replays every scenario: each directory holds the target in base.yaml and steps
+// named <n>-apply.yaml or <n>-delete.yaml, holding the VirtualServiceMerges to apply or delete.
+// The target after each step is compared with <n>-expected.yaml.
+func TestScenarios(t *testing.T) {
+	bases, err := filepath.Glob(filepath.Join("*", "base.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bases) == 0 {
+		t.Fatal("no scenario found")
+	}
+	for _, base := range bases {
+		dir := filepath.Dir(base)
+		t.Run(dir, func(t *testing.T) {
+			runScenario(t, dir)
+		})
+	}
+}
+
+func TestReadSteps(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"base.yaml", "2-apply.yaml", "10-delete.yaml", "1-apply.yaml", "10-expected.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	steps, err := readSteps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.name)
+	}
+	if got := strings.Join(names, ","); got != "1,2,10" {
+		t.Errorf("the steps run in the order %s, expected 1,2,10", got)
+	}
+
+	for _, name := range []string{"first-apply.yaml", "01-delete.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readSteps(dir); err == nil {
+			t.Errorf("the step %s is accepted", name)
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type step struct {
+	name   string
+	number int
+	path   string
+	remove bool
+}
+
+func runScenario(t *testing.T, dir string) {
+	target, err := cli.ReadVirtualService(filepath.Join(dir, "base.yaml"), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps, err := readSteps(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := map[types.NamespacedName]*v1alpha1.VirtualServiceMerge{}
+	for _, s := range steps {
+		merges, err := cli.ReadMerges([]string{s.path}, "default")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var patches []merge.Patch
+		for _, m := range merges {
+			key := types.NamespacedName{Namespace: m.Namespace, Name: m.Name}
+			if s.remove {
+				// the operator removes the merge as it was last applied
+				if applied[key] == nil {
+					t.Fatalf("%s: %s was not applied", s.path, key)
+				}
+				m = applied[key]
+				delete(applied, key)
+			} else {
+				applied[key] = m
+			}
+			if m.Delegates() {
+				m = m.Delegated(logr.Discard())
+			}
+			patches = append(patches, m.MergePatch())
+		}
+		spec, _, err := merge.Merge(&target.Spec, patches, merge.Options{Remove: s.remove})
+		if err != nil {
+			t.Fatalf("%s: %s", s.path, err)
+		}
+		target = target.DeepCopy()
+		target.Spec = *spec
+		checkGolden(t, filepath.Join(dir, s.name+"-expected.yaml"), target)
+	}
+}
+
+// readSteps lists the steps of the scenario in order
+func readSteps(dir string) ([]step, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var steps []step
+	numbers := map[int]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var s step
+		switch {
+		case strings.HasSuffix(name, "-apply.yaml"):
+			s = step{name: strings.TrimSuffix(name, "-apply.yaml"), path: filepath.Join(dir, name)}
+		case strings.HasSuffix(name, "-delete.yaml"):
+			s = step{name: strings.TrimSuffix(name, "-delete.yaml"), path: filepath.Join(dir, name), remove: true}
+		case name == "base.yaml" || strings.HasSuffix(name, "-expected.yaml"):
+			continue
+		default:
+			return nil, fmt.Errorf("%s: unexpected file %s", dir, name)
+		}
+		// the steps run in the order of their numbers, whatever their padding
+		if s.number, err = strconv.Atoi(s.name); err != nil || s.number < 0 {
+			return nil, fmt.Errorf("%s: the step %s is not named after its number", dir, name)
+		}
+		if other, ok := numbers[s.number]; ok {
+			return nil, fmt.Errorf("%s: the steps %s and %s have the same number", dir, other, name)
+		}
+		numbers[s.number] = name
+		steps = append(steps, s)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].number < steps[j].number })
+	return steps, nil
+}
+
+func checkGolden(t *testing.T, path string, target *istio.VirtualService) {
+	t.Helper()
+	got, err := diff.Marshal(target, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s; run the tests with -update to create it", err)
+	}
+	if d := diff.Lines(string(want), string(got), 3); d != "" {
+		t.Errorf("%s differs from the result:\n%s", path, d)
+	}
+}